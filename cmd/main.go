@@ -1,19 +1,25 @@
 package main
 
 import (
+	"crypto/sha256"
 	"datenkarte/internal/handlers"
+	"datenkarte/internal/jobs"
 	"datenkarte/internal/mapping"
 	"datenkarte/internal/middlewares"
 	"datenkarte/internal/models"
 	"datenkarte/internal/networking"
+	"datenkarte/internal/pipeline"
 	"datenkarte/internal/plugins"
-	"datenkarte/internal/validation"
-	"encoding/csv"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
@@ -42,24 +48,150 @@ func buildNestedMap(key string, value interface{}) map[string]interface{} {
 	return m
 }
 
-func uploadCSV(rule models.Rule, pm *plugins.PluginManager) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		// Execute ENTER_RULE hook
-		ruleData := map[string]interface{}{
-			"rule_id": rule.ID,
-			"type":    rule.Type,
+// progressSink reports the number of rows written to the wrapped sink
+type progressSink struct {
+	pipeline.Sink
+	progress func(rows int)
+	rows     int
+}
+
+func (s *progressSink) Write(payload map[string]interface{}) error {
+	if err := s.Sink.Write(payload); err != nil {
+		return err
+	}
+	s.rows++
+	s.progress(s.rows)
+	return nil
+}
+
+// runUpload processes src for the rule as the job with the given ID and
+// delivers the payloads to its HTTP target. Rejected rows are written to the
+// job's rejected rows file. progress, if set, is called after every
+// delivered row. The returned delivery is nil if the target could not be
+// set up.
+func runUpload(rule models.Rule, pm *plugins.PluginManager, jm *jobs.Manager, id string, src io.ReadSeeker, progress func(rows int)) (*pipeline.Result, *networking.Delivery, error) {
+	delivery, err := networking.NewDelivery(rule)
+	if err != nil {
+		return nil, nil, &pipeline.Error{Stage: pipeline.StageDelivery, Err: err}
+	}
+
+	rejects, err := pipeline.CreateRejects(jm.RejectedPath(id), rule)
+	if err != nil {
+		log.Printf("Failed to create rejected rows file for job %s: %v", id, err)
+	}
+	defer rejects.Close()
+
+	var sink pipeline.Sink = delivery
+	if progress != nil {
+		sink = &progressSink{Sink: delivery, progress: progress}
+	}
+
+	result, err := pipeline.Run(rule, pm, src, sink, rejects)
+	return result, delivery, err
+}
+
+// rejectedURL returns where the rejected rows of a job can be downloaded.
+func rejectedURL(id string) string {
+	return "/dk/jobs/" + id + "/rejected"
+}
+
+// outcomeOf converts the result of runUpload for the job into a job outcome.
+func outcomeOf(id string, result *pipeline.Result, delivery *networking.Delivery, err error) jobs.Outcome {
+	outcome := jobs.Outcome{Err: err}
+	if delivery != nil {
+		outcome.Batches = delivery.Results()
+		outcome.PerRow = delivery.RowStats()
+	}
+	if result != nil {
+		outcome.ProcessedRows = result.ProcessedRows
+		outcome.FailedRows = result.FailedRows
+		outcome.RowErrors = result.Errors
+		if result.FailedRows > 0 {
+			outcome.RejectedURL = rejectedURL(id)
 		}
+	}
+	return outcome
+}
 
-		if _, err := pm.ExecuteHook(plugins.ENTER_RULE, ruleData); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Plugin execution failed: %v", err)})
-			return
+// resultDetails returns the row counts and row errors of a result for an
+// upload response.
+func resultDetails(result *pipeline.Result) gin.H {
+	details := gin.H{}
+	if result == nil {
+		return details
+	}
+	details["processed_rows"] = result.ProcessedRows
+	details["failed_rows"] = result.FailedRows
+	if len(result.Errors) > 0 {
+		details["errors"] = result.Errors
+	}
+	return details
+}
+
+// submitUpload copies the uploaded file to a temporary file and processes it
+// in a background job, so the request returns before the file is processed.
+func submitUpload(rule models.Rule, pm *plugins.PluginManager, jm *jobs.Manager, upload jobs.Upload, src io.Reader) (jobs.Job, error) {
+	spool, err := os.CreateTemp("", "datenkarte-upload-*")
+	if err != nil {
+		return jobs.Job{}, fmt.Errorf("failed to store upload: %v", err)
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(spool, hash), src); err != nil {
+		spool.Close()
+		os.Remove(spool.Name())
+		return jobs.Job{}, fmt.Errorf("failed to store upload: %v", err)
+	}
+	upload.FileHash = hex.EncodeToString(hash.Sum(nil))
+
+	return jm.Submit(upload, func(id string) jobs.Outcome {
+		defer os.Remove(spool.Name())
+		defer spool.Close()
+
+		if _, err := spool.Seek(0, io.SeekStart); err != nil {
+			return jobs.Outcome{Err: fmt.Errorf("failed to read upload: %v", err)}
 		}
 
+		result, delivery, err := runUpload(rule, pm, jm, id, spool, func(rows int) {
+			jm.Progress(id, rows)
+		})
+		return outcomeOf(id, result, delivery, err)
+	})
+}
+
+// hashFile returns the hex encoded SHA-256 of f and rewinds it.
+func hashFile(f io.ReadSeeker) (string, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// uploaderOf identifies who sent the request, by the configured header set
+// by an authenticating proxy or else by the client IP.
+func uploaderOf(c *gin.Context, config models.Jobs) string {
+	if config.UploaderHeader != "" {
+		if uploader := c.GetHeader(config.UploaderHeader); uploader != "" {
+			return uploader
+		}
+	}
+	return c.ClientIP()
+}
+
+func uploadCSV(rule models.Rule, pm *plugins.PluginManager, jm *jobs.Manager, config models.Jobs) gin.HandlerFunc {
+	return func(c *gin.Context) {
 		queries := c.Request.URL.Query()
 		dry := false
 		if queries.Get("dry") != "" {
 			dry = true
 		}
+		async := rule.Async
+		if queries.Get("async") != "" {
+			async = true
+		}
 
 		file, err := c.FormFile("file")
 		if err != nil {
@@ -75,72 +207,182 @@ func uploadCSV(rule models.Rule, pm *plugins.PluginManager) gin.HandlerFunc {
 		}
 		defer fileOpen.Close()
 
-		delimiter := rule.Delimiter
-
-		if delimiter == "" {
-			delimiter = ";"
-		}
+		if dry {
+			collector := &pipeline.Collector{}
+			if result, err := pipeline.Run(rule, pm, fileOpen, collector, nil); err != nil {
+				raisePipelineError(c, err, resultDetails(result))
+				return
+			}
 
-		reader := csv.NewReader(fileOpen)
-		reader.Comma = []rune(delimiter)[0]
-		records, err := reader.ReadAll()
-		if err != nil {
-			log.Printf("%v", err)
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse CSV file"})
+			var response interface{} = collector.Payloads
+			if rule.Http != nil && rule.Http.PayloadKey != "" {
+				response = buildNestedMap(rule.Http.PayloadKey, collector.Payloads)
+			}
+			c.JSON(http.StatusOK, response)
 			return
 		}
 
-		var payloads []map[string]interface{}
-
-		headers := records[0]
-		processedRows := 0
-		for i, line := range records[1:] {
-			if err := validation.ValidateLine(c, line, headers, rule); err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Validation failed: %v", err)})
-				return
-			}
+		upload := jobs.Upload{
+			RuleID:   rule.ID,
+			Uploader: uploaderOf(c, config),
+			FileName: file.Filename,
+			FileSize: file.Size,
+		}
 
-			jsonPayload, err := mapping.MapLineToJSON(line, headers, rule, i, pm)
+		if async {
+			job, err := submitUpload(rule, pm, jm, upload, fileOpen)
 			if err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Mapping failed: %v", err)})
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 				return
 			}
+			c.JSON(http.StatusAccepted, gin.H{"status": "accepted", "job_id": job.ID, "job": job})
+			return
+		}
 
-			payloads = append(payloads, jsonPayload)
-			processedRows++
+		if upload.FileHash, err = hashFile(fileOpen); err != nil {
+			RaiseBadRequest(c, "could not read csv.", err)
+			return
 		}
 
-		var response interface{}
-		if rule.Http.PayloadKey != "" {
-			response = buildNestedMap(rule.Http.PayloadKey, payloads)
-		} else {
-			response = payloads
+		var (
+			result   *pipeline.Result
+			delivery *networking.Delivery
+			runErr   error
+		)
+		job, err := jm.Run(upload, func(id string) jobs.Outcome {
+			result, delivery, runErr = runUpload(rule, pm, jm, id, fileOpen, nil)
+			return outcomeOf(id, result, delivery, runErr)
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
 		}
 
-		// Execute EXIT_RULE hook before HTTP operations
-		exitData := map[string]interface{}{
-			"rule_id":        rule.ID,
-			"processed_rows": processedRows,
-			"payloads":       payloads,
+		details := resultDetails(result)
+		details["job_id"] = job.ID
+		if delivery != nil {
+			details["batches"] = delivery.Results()
+			if stats := delivery.RowStats(); stats != nil {
+				details["per_row"] = stats
+			}
+		}
+		if job.RejectedURL != "" {
+			details["rejected_url"] = job.RejectedURL
+		}
+		if runErr != nil {
+			raisePipelineError(c, runErr, details)
+			return
 		}
 
-		if _, err := pm.ExecuteHook(plugins.EXIT_RULE, exitData); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Plugin execution failed: %v", err)})
+		details["status"] = "success"
+		c.JSON(http.StatusOK, details)
+	}
+}
+
+func getJob(jm *jobs.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		job, exists, err := jm.Get(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if !exists {
+			c.JSON(http.StatusNotFound, gin.H{"error": "job not found"})
 			return
 		}
+		c.JSON(http.StatusOK, job)
+	}
+}
 
-		// start http stuff
-		if dry {
-			c.JSON(http.StatusOK, response)
+// getRejected serves the rejected rows of a job as a CSV download.
+func getRejected(jm *jobs.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		job, exists, err := jm.Get(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if !exists || job.RejectedURL == "" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no rejected rows for this job"})
+			return
+		}
+
+		path := jm.RejectedPath(job.ID)
+		if _, err := os.Stat(path); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no rejected rows for this job"})
 			return
 		}
-		if err := networking.SendPayload(rule, response); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("HTTP Failed: %v", err)})
+
+		name := strings.TrimSuffix(job.FileName, filepath.Ext(job.FileName))
+		if name == "" {
+			name = job.ID
+		}
+		c.FileAttachment(path, name+"-rejected.csv")
+	}
+}
+
+// listJobs returns the job history, filtered by the rule, status and since
+// query parameters. since accepts an RFC 3339 timestamp or a date.
+func listJobs(jm *jobs.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		filter := jobs.Filter{
+			RuleID: c.Query("rule"),
+			State:  jobs.State(c.Query("status")),
+		}
+
+		if since := c.Query("since"); since != "" {
+			t, err := time.Parse(time.RFC3339, since)
+			if err != nil {
+				t, err = time.Parse(time.DateOnly, since)
+			}
+			if err != nil {
+				RaiseBadRequest(c, "since must be an RFC 3339 timestamp or a date.", err)
+				return
+			}
+			filter.Since = t
+		}
+
+		list, err := jm.List(filter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+		c.JSON(http.StatusOK, gin.H{"jobs": list})
+	}
+}
+
+// raisePipelineError responds with the status matching the stage the
+// pipeline failed in, adding the given details to the error body.
+func raisePipelineError(c *gin.Context, err error, details gin.H) {
+	status := http.StatusInternalServerError
+	response := gin.H{"error": err.Error()}
+
+	var pipelineErr *pipeline.Error
+	if errors.As(err, &pipelineErr) {
+		switch pipelineErr.Stage {
+		case pipeline.StageParse:
+			status = http.StatusBadRequest
+			response["error"] = fmt.Sprintf("Failed to parse file: %v", pipelineErr.Err)
+		case pipeline.StageValidation:
+			status = http.StatusBadRequest
+			response["error"] = fmt.Sprintf("Validation failed: %v", pipelineErr.Err)
+		case pipeline.StageMapping:
+			status = http.StatusBadRequest
+			response["error"] = fmt.Sprintf("Mapping failed: %v", pipelineErr.Err)
+		case pipeline.StagePlugin:
+			response["error"] = fmt.Sprintf("Plugin execution failed: %v", pipelineErr.Err)
+		default:
+			response["error"] = fmt.Sprintf("HTTP Failed: %v", pipelineErr.Err)
+		}
+		if pipelineErr.Row > 0 {
+			response["row"] = pipelineErr.Row
+		}
+	}
 
-		c.JSON(http.StatusOK, gin.H{"status": "success", "processed_rows": processedRows})
+	for key, value := range details {
+		response[key] = value
 	}
+	c.JSON(status, response)
 }
 
 func main() {
@@ -171,6 +413,18 @@ func main() {
 		}
 	}
 
+	if err := mapping.LoadLookups(config.Lookups); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	if err := mapping.CheckLookups(config.Rules); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	if err := mapping.CompileExpressions(config.Rules); err != nil {
+		log.Fatalf("%v", err)
+	}
+
 	// starting persistent handlers
 	for _, handler := range config.Handlers {
 		if !handler.Persistent {
@@ -181,15 +435,35 @@ func main() {
 		}
 	}
 
+	var store *jobs.Store
+	if config.Jobs.Store != "" {
+		if store, err = jobs.OpenStore(config.Jobs.Store); err != nil {
+			log.Fatalf("%v", err)
+		}
+		defer store.Close()
+	}
+
+	jm, err := jobs.NewManager(config.Jobs.Workers, store, config.Jobs.RejectedDir)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	jm.StartRetention(config.Jobs.Retention)
+
 	r := gin.Default()
 
 	authGroup := r.Group("/dk/upload")
 	authGroup.Use(middlewares.AuthenticationMiddleware())
 
 	for _, rule := range config.Rules {
-		authGroup.POST(rule.ID, uploadCSV(rule, pm))
+		authGroup.POST(rule.ID, uploadCSV(rule, pm, jm, config.Jobs))
 	}
 
+	jobsGroup := r.Group("/dk/jobs")
+	jobsGroup.Use(middlewares.AuthenticationMiddleware())
+	jobsGroup.GET("", listJobs(jm))
+	jobsGroup.GET(":id", getJob(jm))
+	jobsGroup.GET(":id/rejected", getRejected(jm))
+
 	log.Println("Datenkarte Started.")
 	r.Run()
 }
@@ -34,8 +34,8 @@ func (p LoggerPlugin) OnExitLine(data map[string]interface{}) (map[string]interf
 
 func (p LoggerPlugin) OnExitRule(data map[string]interface{}) (map[string]interface{}, error) {
 	fmt.Printf("[%s] Finished rule processing\n", time.Now().Format(time.RFC3339))
-	if payloads, ok := data["payloads"].([]map[string]interface{}); ok {
-		data["total_processed"] = len(payloads)
+	if processed, ok := data["processed_rows"].(int); ok {
+		data["total_processed"] = processed
 	}
 	return data, nil
 }
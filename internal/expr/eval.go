@@ -0,0 +1,413 @@
+package expr
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Env is what an expression can reference: the columns of the current row,
+// the fields mapped so far and the position of the row.
+type Env struct {
+	Row    map[string]string
+	Mapped map[string]interface{}
+	Index  int
+}
+
+// Program is a parsed expression that can be evaluated for many rows
+type Program struct {
+	source string
+	root   node
+}
+
+var (
+	programs   = map[string]*Program{}
+	programsMu sync.Mutex
+)
+
+// Compile parses an expression once and caches it for the following rows.
+func Compile(source string) (*Program, error) {
+	programsMu.Lock()
+	defer programsMu.Unlock()
+
+	if program, exists := programs[source]; exists {
+		return program, nil
+	}
+	root, err := parse(source)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %v", source, err)
+	}
+	program := &Program{source: source, root: root}
+	programs[source] = program
+	return program, nil
+}
+
+// Eval evaluates the expression. Dates are returned as RFC 3339 strings.
+func (p *Program) Eval(env Env) (interface{}, error) {
+	value, err := p.root.eval(&env)
+	if err != nil {
+		return nil, err
+	}
+	if t, ok := value.(time.Time); ok {
+		return t.Format(time.RFC3339), nil
+	}
+	return value, nil
+}
+
+type node interface {
+	eval(env *Env) (interface{}, error)
+}
+
+type literal struct {
+	value interface{}
+}
+
+func (n *literal) eval(env *Env) (interface{}, error) {
+	return n.value, nil
+}
+
+// name resolves the variables row, mapped, row_number and row_index, then a
+// column of the row and finally an already mapped field.
+type name struct {
+	name string
+}
+
+func (n *name) eval(env *Env) (interface{}, error) {
+	switch n.name {
+	case "row":
+		return env.Row, nil
+	case "mapped":
+		return env.Mapped, nil
+	case "row_number":
+		return float64(env.Index + 1), nil
+	case "row_index":
+		return float64(env.Index), nil
+	}
+	if value, exists := env.Row[n.name]; exists {
+		return value, nil
+	}
+	if value, exists := env.Mapped[n.name]; exists {
+		return normalize(value), nil
+	}
+	return nil, fmt.Errorf("unknown name %s", n.name)
+}
+
+type index struct {
+	target node
+	key    node
+}
+
+func (n *index) eval(env *Env) (interface{}, error) {
+	target, err := n.target.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	key, err := n.key.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	switch target := target.(type) {
+	case map[string]string:
+		if value, exists := target[toString(key)]; exists {
+			return value, nil
+		}
+		return nil, nil
+	case map[string]interface{}:
+		return normalize(target[toString(key)]), nil
+	case []interface{}:
+		i, err := toNumber(key)
+		if err != nil {
+			return nil, err
+		}
+		if int(i) < 0 || int(i) >= len(target) {
+			return nil, nil
+		}
+		return normalize(target[int(i)]), nil
+	case nil:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("cannot index %s", typeName(target))
+}
+
+type unary struct {
+	op      string
+	operand node
+}
+
+func (n *unary) eval(env *Env) (interface{}, error) {
+	value, err := n.operand.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "!" {
+		return !truthy(value), nil
+	}
+	number, err := toNumber(value)
+	if err != nil {
+		return nil, err
+	}
+	return -number, nil
+}
+
+type binary struct {
+	op          string
+	left, right node
+}
+
+func (n *binary) eval(env *Env) (interface{}, error) {
+	left, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "&&":
+		if !truthy(left) {
+			return false, nil
+		}
+		right, err := n.right.eval(env)
+		return truthy(right), err
+	case "||":
+		if truthy(left) {
+			return true, nil
+		}
+		right, err := n.right.eval(env)
+		return truthy(right), err
+	}
+
+	right, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "==":
+		return equal(left, right), nil
+	case "!=":
+		return !equal(left, right), nil
+	case "<":
+		return compare(left, right) < 0, nil
+	case "<=":
+		return compare(left, right) <= 0, nil
+	case ">":
+		return compare(left, right) > 0, nil
+	case ">=":
+		return compare(left, right) >= 0, nil
+	case "+":
+		if l, err := toNumber(left); err == nil {
+			if r, err := toNumber(right); err == nil {
+				return l + r, nil
+			}
+		}
+		return toString(left) + toString(right), nil
+	}
+
+	l, err := toNumber(left)
+	if err != nil {
+		return nil, err
+	}
+	r, err := toNumber(right)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "/":
+		if r == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return l / r, nil
+	case "%":
+		if r == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return math.Mod(l, r), nil
+	}
+	return nil, fmt.Errorf("unknown operator %s", n.op)
+}
+
+type conditional struct {
+	cond, then, otherwise node
+}
+
+func (n *conditional) eval(env *Env) (interface{}, error) {
+	cond, err := n.cond.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if truthy(cond) {
+		return n.then.eval(env)
+	}
+	return n.otherwise.eval(env)
+}
+
+type call struct {
+	name string
+	fn   function
+	args []node
+}
+
+func (n *call) eval(env *Env) (interface{}, error) {
+	args := make([]interface{}, len(n.args))
+	for i, arg := range n.args {
+		value, err := arg.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = value
+	}
+	value, err := n.fn.call(args)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", n.name, err)
+	}
+	return value, nil
+}
+
+// truthy treats false, null, empty strings, 0 and empty arrays as false
+func truthy(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != ""
+	case float64:
+		return v != 0
+	case []interface{}:
+		return len(v) > 0
+	}
+	return true
+}
+
+func toString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case time.Time:
+		return v.Format(time.RFC3339)
+	}
+	return fmt.Sprint(value)
+}
+
+// normalize turns the numbers of other Go types that mapped fields hold, like
+// int from fill or int64 from type integer, into float64
+func normalize(value interface{}) interface{} {
+	switch v := value.(type) {
+	case int:
+		return float64(v)
+	case int8:
+		return float64(v)
+	case int16:
+		return float64(v)
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case uint:
+		return float64(v)
+	case uint8:
+		return float64(v)
+	case uint16:
+		return float64(v)
+	case uint32:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	case float32:
+		return float64(v)
+	case json.Number:
+		if number, err := v.Float64(); err == nil {
+			return number
+		}
+		return v.String()
+	}
+	return value
+}
+
+func toNumber(value interface{}) (float64, error) {
+	switch v := normalize(value).(type) {
+	case float64:
+		return v, nil
+	case string:
+		number, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil || math.IsInf(number, 0) || math.IsNaN(number) {
+			return 0, fmt.Errorf("%q is not a number", v)
+		}
+		return number, nil
+	}
+	return 0, fmt.Errorf("%s is not a number", typeName(value))
+}
+
+// equal compares values of the same type directly and everything else by
+// its string form, so a column "42" equals the number 42
+func equal(left, right interface{}) bool {
+	if left == nil || right == nil {
+		return left == nil && right == nil
+	}
+	if l, ok := left.(time.Time); ok {
+		if r, ok := right.(time.Time); ok {
+			return l.Equal(r)
+		}
+	}
+	if l, err := toNumber(left); err == nil {
+		if r, err := toNumber(right); err == nil {
+			return l == r
+		}
+	}
+	return toString(left) == toString(right)
+}
+
+// compare orders dates by time, values that are both numbers numerically and
+// everything else as strings
+func compare(left, right interface{}) int {
+	if l, ok := left.(time.Time); ok {
+		if r, ok := right.(time.Time); ok {
+			return l.Compare(r)
+		}
+	}
+	if l, err := toNumber(left); err == nil {
+		if r, err := toNumber(right); err == nil {
+			switch {
+			case l < r:
+				return -1
+			case l > r:
+				return 1
+			}
+			return 0
+		}
+	}
+	return strings.Compare(toString(left), toString(right))
+}
+
+func typeName(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case time.Time:
+		return "date"
+	case []interface{}:
+		return "array"
+	case map[string]string, map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
@@ -0,0 +1,210 @@
+package expr
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestEvalMappedNumbers(t *testing.T) {
+	env := Env{
+		Row: map[string]string{"Age": "42"},
+		Mapped: map[string]interface{}{
+			"id":     10,
+			"age":    int64(42),
+			"ratio":  float32(0.5),
+			"amount": json.Number("12.5"),
+			"items":  []interface{}{int64(1), int64(2)},
+			"nested": map[string]interface{}{"count": int64(3)},
+		},
+	}
+
+	tests := []struct {
+		source string
+		want   interface{}
+	}{
+		{"id + 1", 11.0},
+		{"id * 2", 20.0},
+		{"age + 1", 43.0},
+		{"age - Age", 0.0},
+		{"ratio * 4", 2.0},
+		{"amount + 0.5", 13.0},
+		{"items[1] + 1", 3.0},
+		{"nested.count * 2", 6.0},
+		{"mapped.age + 1", 43.0},
+		{"age == 42", true},
+		{"age > id", true},
+		{"round(age / 5)", 8.0},
+		{"string(age) + '!'", "42!"},
+		{"Age + 1", 43.0},
+		{"Age + age", 84.0},
+	}
+	for _, test := range tests {
+		got := mustEval(t, test.source, env)
+		if got != test.want {
+			t.Errorf("%s = %#v, want %#v", test.source, got, test.want)
+		}
+	}
+}
+
+func mustEval(t *testing.T, source string, env Env) interface{} {
+	t.Helper()
+	program, err := Compile(source)
+	if err != nil {
+		t.Fatalf("Compile(%q): %v", source, err)
+	}
+	value, err := program.Eval(env)
+	if err != nil {
+		t.Fatalf("Eval(%q): %v", source, err)
+	}
+	return value
+}
+
+func TestEval(t *testing.T) {
+	env := Env{
+		Row: map[string]string{
+			"First name": "Ada",
+			"Last name":  "Lovelace",
+			"Amount":     "12.5",
+			"Born":       "1815-12-10",
+			"Empty":      "",
+		},
+		Mapped: map[string]interface{}{"tags": []interface{}{"a", "b"}},
+		Index:  4,
+	}
+
+	tests := []struct {
+		source string
+		want   interface{}
+	}{
+		// Literals and operators
+		{"1 + 2 * 3", 7.0},
+		{"(1 + 2) * 3", 9.0},
+		{"10 - 4 - 3", 3.0},
+		{"7 % 4", 3.0},
+		{"-2 * -3", 6.0},
+		{"1 < 2 && 2 <= 2", true},
+		{"1 > 2 || !(3 >= 4)", true},
+		{"'a' + 'b'", "ab"},
+		{"'n' + 1", "n1"},
+		{"'4' + '1'", 5.0},
+		{"'NaN' + 1", "NaN1"},
+		{"null == null", true},
+		{"null != ''", true},
+		{"true ? 'yes' : 'no'", "yes"},
+		{"false ? 1 : true ? 2 : 3", 2.0},
+
+		// Names
+		{"row['First name'] + ' ' + row['Last name']", "Ada Lovelace"},
+		{"Amount * 2", 25.0},
+		{"Amount + 1", 13.5},
+		{"Empty + 1", "1"},
+		{"concat(Amount, 1)", "12.51"},
+		{"Amount == 12.5", true},
+		{"row_number", 5.0},
+		{"row_index", 4.0},
+		{"row.Missing", nil},
+		{"mapped.tags[0]", "a"},
+		{"mapped.tags[5]", nil},
+
+		// Strings
+		{"upper(row['Last name'])", "LOVELACE"},
+		{"lower(trim('  Ada '))", "ada"},
+		{"concat('a', 1, true)", "a1true"},
+		{"substr('Lovelace', 0, 4)", "Love"},
+		{"substr('Lovelace', 4)", "lace"},
+		{"substr('abc', 5)", ""},
+		{"replace('a-b-c', '-', '/')", "a/b/c"},
+		{"contains('Lovelace', 'lace')", true},
+		{"startsWith('Lovelace', 'Love')", true},
+		{"endsWith('Lovelace', 'x')", false},
+		{"len('Grüße')", 5.0},
+		{"len(mapped.tags)", 2.0},
+		{"join(split('a,b,c', ','), ';')", "a;b;c"},
+		{"padLeft('7', 3, '0')", "007"},
+		{"padLeft('1234', 3, '0')", "1234"},
+		{"padLeft('7', 6, 'ab')", "ababa7"},
+		{"padLeft('7', 4, 'äöü')", "äöü7"},
+		{"padLeft('7', 5, 'äöü')", "äöüä7"},
+
+		// Math
+		{"number(' 3.5 ')", 3.5},
+		{"round(2.5)", 3.0},
+		{"round(-2.5)", -3.0},
+		{"round(1.2345, 2)", 1.23},
+		{"floor(1.7) + ceil(1.2)", 3.0},
+		{"abs(-4)", 4.0},
+		{"min(3, 1, 2)", 1.0},
+		{"max(3, '10', 2)", 10.0},
+
+		// Dates
+		{"date(Born)", "1815-12-10T00:00:00Z"},
+		{"format(Born, '02.01.2006')", "10.12.1815"},
+		{"format(date('10/12/1815', '02/01/2006'), '2006-01-02')", "1815-12-10"},
+		{"format(addDays(Born, 30), '2006-01-02')", "1816-01-09"},
+		{"diffDays('2024-01-01', '2024-03-01')", 60.0},
+		{"year(Born) + month(Born) + day(Born)", 1837.0},
+		{"date(Born) < date('1900-01-01')", true},
+
+		// Conditionals
+		{"if(Empty, 'set', 'unset')", "unset"},
+		{"coalesce(Empty, null, 'fallback')", "fallback"},
+		{"empty(Empty) && !empty(Amount)", true},
+	}
+	for _, test := range tests {
+		got := mustEval(t, test.source, env)
+		if got != test.want {
+			t.Errorf("%s = %#v, want %#v", test.source, got, test.want)
+		}
+	}
+}
+
+func TestEvalShortCircuits(t *testing.T) {
+	env := Env{Row: map[string]string{}}
+	tests := []string{
+		"false && unknown",
+		"true || unknown",
+		"true ? 1 : unknown",
+		"if(false, unknown, 1)",
+	}
+	for _, source := range tests {
+		mustEval(t, source, env)
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	env := Env{
+		Row:    map[string]string{"Name": "Ada"},
+		Mapped: map[string]interface{}{"flag": true},
+	}
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"missing", "unknown name missing"},
+		{"Name * 2", `"Ada" is not a number`},
+		{"flag - 1", "boolean is not a number"},
+		{"1 / 0", "division by zero"},
+		{"1 % 0", "division by zero"},
+		{"-Name", `"Ada" is not a number`},
+		{"true[0]", "cannot index boolean"},
+		{"date('not a date')", `date: "not a date" is not a date`},
+		{"join('a', ',')", "join: expected an array, got string"},
+		{"padLeft('a', 3, '')", "padLeft: padding must not be empty"},
+		{"padLeft('a', 100000, ' ')", "padLeft: width must be at most 65536"},
+	}
+	for _, test := range tests {
+		program, err := Compile(test.source)
+		if err != nil {
+			t.Errorf("Compile(%q): %v", test.source, err)
+			continue
+		}
+		_, err = program.Eval(env)
+		if err == nil {
+			t.Errorf("%s succeeded, want error %q", test.source, test.want)
+			continue
+		}
+		if err.Error() != test.want {
+			t.Errorf("%s error = %q, want %q", test.source, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxStringLength limits strings built by pad functions
+const maxStringLength = 1 << 16
+
+// defaultDateLayout is used by date functions without a layout
+const defaultDateLayout = "2006-01-02"
+
+// function is a built-in with its accepted number of arguments, max -1
+// meaning any number
+type function struct {
+	min, max int
+	call     func(args []interface{}) (interface{}, error)
+}
+
+func (f function) arity() string {
+	switch {
+	case f.min == f.max:
+		return fmt.Sprint(f.min)
+	case f.max < 0:
+		return fmt.Sprintf("at least %d", f.min)
+	}
+	return fmt.Sprintf("%d to %d", f.min, f.max)
+}
+
+var functions map[string]function
+
+func init() {
+	functions = map[string]function{
+		// Strings
+		"lower":      {1, 1, stringFunc(strings.ToLower)},
+		"upper":      {1, 1, stringFunc(strings.ToUpper)},
+		"trim":       {1, 1, stringFunc(strings.TrimSpace)},
+		"string":     {1, 1, func(args []interface{}) (interface{}, error) { return toString(args[0]), nil }},
+		"concat":     {0, -1, concat},
+		"substr":     {2, 3, substr},
+		"replace":    {3, 3, replace},
+		"contains":   {2, 2, stringTest(strings.Contains)},
+		"startsWith": {2, 2, stringTest(strings.HasPrefix)},
+		"endsWith":   {2, 2, stringTest(strings.HasSuffix)},
+		"len":        {1, 1, length},
+		"split":      {2, 2, split},
+		"join":       {2, 2, join},
+		"padLeft":    {3, 3, padLeft},
+
+		// Math
+		"number": {1, 1, func(args []interface{}) (interface{}, error) { return toNumber(args[0]) }},
+		"round":  {1, 2, round},
+		"floor":  {1, 1, mathFunc(math.Floor)},
+		"ceil":   {1, 1, mathFunc(math.Ceil)},
+		"abs":    {1, 1, mathFunc(math.Abs)},
+		"min":    {1, -1, extreme(-1)},
+		"max":    {1, -1, extreme(1)},
+
+		// Dates
+		"now":      {0, 0, func(args []interface{}) (interface{}, error) { return time.Now(), nil }},
+		"date":     {1, 2, date},
+		"format":   {2, 2, format},
+		"addDays":  {2, 2, addDays},
+		"diffDays": {2, 2, diffDays},
+		"year":     {1, 1, datePart(func(t time.Time) int { return t.Year() })},
+		"month":    {1, 1, datePart(func(t time.Time) int { return int(t.Month()) })},
+		"day":      {1, 1, datePart(func(t time.Time) int { return t.Day() })},
+
+		// Conditionals, if is handled by the parser to evaluate lazily
+		"coalesce": {1, -1, coalesce},
+		"empty":    {1, 1, func(args []interface{}) (interface{}, error) { return !truthy(args[0]), nil }},
+	}
+}
+
+func stringFunc(fn func(string) string) func([]interface{}) (interface{}, error) {
+	return func(args []interface{}) (interface{}, error) {
+		return fn(toString(args[0])), nil
+	}
+}
+
+func stringTest(fn func(string, string) bool) func([]interface{}) (interface{}, error) {
+	return func(args []interface{}) (interface{}, error) {
+		return fn(toString(args[0]), toString(args[1])), nil
+	}
+}
+
+func mathFunc(fn func(float64) float64) func([]interface{}) (interface{}, error) {
+	return func(args []interface{}) (interface{}, error) {
+		number, err := toNumber(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return fn(number), nil
+	}
+}
+
+func concat(args []interface{}) (interface{}, error) {
+	var b strings.Builder
+	for _, arg := range args {
+		b.WriteString(toString(arg))
+	}
+	return b.String(), nil
+}
+
+// substr returns length runes from start, or the rest if no length is given
+func substr(args []interface{}) (interface{}, error) {
+	runes := []rune(toString(args[0]))
+	start, err := toNumber(args[1])
+	if err != nil {
+		return nil, err
+	}
+	from := clamp(int(start), len(runes))
+	to := len(runes)
+	if len(args) == 3 {
+		length, err := toNumber(args[2])
+		if err != nil {
+			return nil, err
+		}
+		to = clamp(from+int(length), len(runes))
+	}
+	if to < from {
+		return "", nil
+	}
+	return string(runes[from:to]), nil
+}
+
+func clamp(i, n int) int {
+	if i < 0 {
+		return 0
+	}
+	if i > n {
+		return n
+	}
+	return i
+}
+
+func replace(args []interface{}) (interface{}, error) {
+	return strings.ReplaceAll(toString(args[0]), toString(args[1]), toString(args[2])), nil
+}
+
+func length(args []interface{}) (interface{}, error) {
+	if list, ok := args[0].([]interface{}); ok {
+		return float64(len(list)), nil
+	}
+	return float64(utf8.RuneCountInString(toString(args[0]))), nil
+}
+
+func split(args []interface{}) (interface{}, error) {
+	parts := strings.Split(toString(args[0]), toString(args[1]))
+	list := make([]interface{}, len(parts))
+	for i, part := range parts {
+		list[i] = part
+	}
+	return list, nil
+}
+
+func join(args []interface{}) (interface{}, error) {
+	list, ok := args[0].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected an array, got %s", typeName(args[0]))
+	}
+	parts := make([]string, len(list))
+	for i, item := range list {
+		parts[i] = toString(item)
+	}
+	return strings.Join(parts, toString(args[1])), nil
+}
+
+func padLeft(args []interface{}) (interface{}, error) {
+	value := toString(args[0])
+	width, err := toNumber(args[1])
+	if err != nil {
+		return nil, err
+	}
+	pad := toString(args[2])
+	if pad == "" {
+		return nil, fmt.Errorf("padding must not be empty")
+	}
+	if width > maxStringLength {
+		return nil, fmt.Errorf("width must be at most %d", maxStringLength)
+	}
+	missing := int(width) - utf8.RuneCountInString(value)
+	if missing <= 0 {
+		return value, nil
+	}
+	n := utf8.RuneCountInString(pad)
+	padding := []rune(strings.Repeat(pad, (missing+n-1)/n))
+	return string(padding[:missing]) + value, nil
+}
+
+// round rounds half away from zero to the given number of decimal places
+func round(args []interface{}) (interface{}, error) {
+	number, err := toNumber(args[0])
+	if err != nil {
+		return nil, err
+	}
+	places := 0.0
+	if len(args) == 2 {
+		if places, err = toNumber(args[1]); err != nil {
+			return nil, err
+		}
+	}
+	factor := math.Pow(10, places)
+	return math.Round(number*factor) / factor, nil
+}
+
+// extreme returns the smallest (sign -1) or largest (sign 1) number
+func extreme(sign float64) func([]interface{}) (interface{}, error) {
+	return func(args []interface{}) (interface{}, error) {
+		var result float64
+		for i, arg := range args {
+			number, err := toNumber(arg)
+			if err != nil {
+				return nil, err
+			}
+			if i == 0 || (number-result)*sign > 0 {
+				result = number
+			}
+		}
+		return result, nil
+	}
+}
+
+// toTime accepts dates, strings in the layout and, without a layout, strings
+// in RFC 3339 or 2006-01-02
+func toTime(value interface{}, layout string) (time.Time, error) {
+	if t, ok := value.(time.Time); ok {
+		return t, nil
+	}
+	s := toString(value)
+	if layout != "" {
+		return time.Parse(layout, s)
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(defaultDateLayout, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is not a date", s)
+	}
+	return t, nil
+}
+
+func date(args []interface{}) (interface{}, error) {
+	layout := ""
+	if len(args) == 2 {
+		layout = toString(args[1])
+	}
+	return toTime(args[0], layout)
+}
+
+func format(args []interface{}) (interface{}, error) {
+	t, err := toTime(args[0], "")
+	if err != nil {
+		return nil, err
+	}
+	return t.Format(toString(args[1])), nil
+}
+
+func addDays(args []interface{}) (interface{}, error) {
+	t, err := toTime(args[0], "")
+	if err != nil {
+		return nil, err
+	}
+	days, err := toNumber(args[1])
+	if err != nil {
+		return nil, err
+	}
+	return t.AddDate(0, 0, int(days)), nil
+}
+
+// diffDays returns the number of whole days from the first to the second date
+func diffDays(args []interface{}) (interface{}, error) {
+	from, err := toTime(args[0], "")
+	if err != nil {
+		return nil, err
+	}
+	to, err := toTime(args[1], "")
+	if err != nil {
+		return nil, err
+	}
+	return math.Trunc(to.Sub(from).Hours() / 24), nil
+}
+
+func datePart(fn func(time.Time) int) func([]interface{}) (interface{}, error) {
+	return func(args []interface{}) (interface{}, error) {
+		t, err := toTime(args[0], "")
+		if err != nil {
+			return nil, err
+		}
+		return float64(fn(t)), nil
+	}
+}
+
+// coalesce returns the first argument that is neither null nor empty
+func coalesce(args []interface{}) (interface{}, error) {
+	for _, arg := range args {
+		if arg != nil && arg != "" {
+			return arg, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,111 @@
+package expr
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		source string
+		want   []string
+	}{
+		{"a+1", []string{"a", "+", "1"}},
+		{"x >= 2.5 && !y", []string{"x", ">=", "2.5", "&&", "!", "y"}},
+		{`"a b" == 'c'`, []string{`"a b"`, "==", "'c'"}},
+		{"row.Last_name[0]", []string{"row", ".", "Last_name", "[", "0", "]"}},
+		{"f(a, b)", []string{"f", "(", "a", ",", "b", ")"}},
+		{"  ", nil},
+	}
+	for _, test := range tests {
+		tokens, err := tokenize(test.source)
+		if err != nil {
+			t.Errorf("tokenize(%q): %v", test.source, err)
+			continue
+		}
+		var got []string
+		for _, token := range tokens {
+			if token.kind != tokenEOF {
+				got = append(got, token.text)
+			}
+		}
+		if strings.Join(got, " ") != strings.Join(test.want, " ") {
+			t.Errorf("tokenize(%q) = %q, want %q", test.source, got, test.want)
+		}
+	}
+}
+
+func TestTokenizeStrings(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{`"plain"`, "plain"},
+		{`'single'`, "single"},
+		{`"say \"hi\""`, `say "hi"`},
+		{`'it\'s'`, "it's"},
+		{`"a\nb\tc"`, "a\nb\tc"},
+		{`"Grüße"`, "Grüße"},
+	}
+	for _, test := range tests {
+		tokens, err := tokenize(test.source)
+		if err != nil {
+			t.Errorf("tokenize(%q): %v", test.source, err)
+			continue
+		}
+		if tokens[0].kind != tokenString || tokens[0].value != test.want {
+			t.Errorf("tokenize(%q) = %#v, want string %q", test.source, tokens[0].value, test.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"", "unexpected end of expression"},
+		{"1 +", "unexpected end of expression"},
+		{"(1 + 2", "expected ) at end of expression"},
+		{"a b", "unexpected b at 2"},
+		{`"open`, "unterminated string at 0"},
+		{"1.2.3", "invalid number 1.2.3 at 0"},
+		{"a # b", "unexpected character '#' at 2"},
+		{"a ? b", "expected : at end of expression"},
+		{"a.1", "expected field name at 2"},
+		{"nope(1)", "unknown function nope"},
+		{"upper()", "upper expects 1 arguments, got 0"},
+		{"substr('a')", "substr expects 2 to 3 arguments, got 1"},
+		{"min()", "min expects at least 1 arguments, got 0"},
+		{"if(a, b)", "if expects 3 arguments, got 2"},
+		{strings.Repeat("a", maxLength+1), "expression longer than 4096 characters"},
+	}
+	for _, test := range tests {
+		_, err := parse(test.source)
+		if err == nil {
+			t.Errorf("parse(%q) succeeded, want error %q", test.source, test.want)
+			continue
+		}
+		if err.Error() != test.want {
+			t.Errorf("parse(%q) error = %q, want %q", test.source, err, test.want)
+		}
+	}
+}
+
+func TestCompileCaches(t *testing.T) {
+	first, err := Compile("1 + 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := Compile("1 + 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Error("Compile returned a new program for the same source")
+	}
+
+	if _, err := Compile("1 +"); err == nil || !strings.HasPrefix(err.Error(), `invalid expression "1 +"`) {
+		t.Errorf("Compile error = %v, want it to name the expression", err)
+	}
+}
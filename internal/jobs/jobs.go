@@ -0,0 +1,367 @@
+package jobs
+
+import (
+	"crypto/rand"
+	"datenkarte/internal/networking"
+	"datenkarte/internal/validation"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type State string
+
+const (
+	Queued    State = "queued"
+	Running   State = "running"
+	Succeeded State = "succeeded"
+	Failed    State = "failed"
+)
+
+// Upload describes the file a job processes and who sent it
+type Upload struct {
+	RuleID   string `json:"rule_id"`
+	Uploader string `json:"uploader,omitempty"`
+	FileName string `json:"file_name,omitempty"`
+	FileHash string `json:"file_hash,omitempty"`
+	FileSize int64  `json:"file_size"`
+	Async    bool   `json:"async"`
+}
+
+// Job tracks the processing of a single upload
+type Job struct {
+	ID string `json:"id"`
+	Upload
+	State         State                    `json:"state"`
+	ProcessedRows int                      `json:"processed_rows"`
+	FailedRows    int                      `json:"failed_rows"`
+	Batches       []networking.BatchResult `json:"batches,omitempty"`
+	PerRow        *networking.RowStats     `json:"per_row,omitempty"`
+	Errors        []string                 `json:"errors,omitempty"`
+	RowErrors     []validation.FieldError  `json:"row_errors,omitempty"`
+	RejectedURL   string                   `json:"rejected_url,omitempty"`
+	CreatedAt     time.Time                `json:"created_at"`
+	StartedAt     *time.Time               `json:"started_at,omitempty"`
+	FinishedAt    *time.Time               `json:"finished_at,omitempty"`
+}
+
+// maxFinishedJobs limits the finished jobs kept in memory without a store,
+// the oldest are dropped first.
+const maxFinishedJobs = 100
+
+// Outcome is what a job's work function reports when it is done
+type Outcome struct {
+	ProcessedRows int
+	FailedRows    int
+	Batches       []networking.BatchResult
+	PerRow        *networking.RowStats
+	RowErrors     []validation.FieldError
+	RejectedURL   string
+	Err           error
+}
+
+// Manager runs jobs with a limited number of background workers. Jobs are
+// kept in memory while they are active and, if a store is set, persisted
+// on every state change so the history survives restarts. Without a store
+// only the last maxFinishedJobs finished jobs are kept.
+type Manager struct {
+	jobs        map[string]*Job
+	finished    []string
+	workers     chan struct{}
+	store       *Store
+	rejectedDir string
+	mu          sync.RWMutex
+}
+
+// NewManager creates a manager keeping the rejected rows of its jobs in
+// rejectedDir, which is created if needed.
+func NewManager(workers int, store *Store, rejectedDir string) (*Manager, error) {
+	if workers <= 0 {
+		workers = 1
+	}
+	if rejectedDir == "" {
+		rejectedDir = filepath.Join(os.TempDir(), "datenkarte-rejected")
+	}
+	if err := os.MkdirAll(rejectedDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create rejected rows directory: %w", err)
+	}
+
+	m := &Manager{
+		jobs:        make(map[string]*Job),
+		workers:     make(chan struct{}, workers),
+		store:       store,
+		rejectedDir: rejectedDir,
+	}
+	m.failInterrupted()
+	return m, nil
+}
+
+// RejectedPath returns the path of the rejected rows file of a job.
+func (m *Manager) RejectedPath(id string) string {
+	return filepath.Join(m.rejectedDir, id+".csv")
+}
+
+// failInterrupted marks stored jobs that were still active when the process
+// stopped as failed, since nothing will pick them up again.
+func (m *Manager) failInterrupted() {
+	if m.store == nil {
+		return
+	}
+
+	for _, state := range []State{Queued, Running} {
+		interrupted, err := m.store.List(Filter{State: state})
+		if err != nil {
+			log.Printf("Failed to read job store: %v", err)
+			return
+		}
+		for _, job := range interrupted {
+			now := time.Now()
+			job.State = Failed
+			job.FinishedAt = &now
+			job.Errors = append(job.Errors, "interrupted by restart")
+			if err := m.store.Save(job); err != nil {
+				log.Printf("Failed to persist job %s: %v", job.ID, err)
+			}
+		}
+	}
+}
+
+// Submit queues work for the upload and returns the new job right away. The
+// work function receives the job ID and reports progress through Progress.
+func (m *Manager) Submit(upload Upload, work func(id string) Outcome) (Job, error) {
+	upload.Async = true
+	job, err := m.create(upload)
+	if err != nil {
+		return Job{}, err
+	}
+
+	go func() {
+		m.workers <- struct{}{}
+		defer func() { <-m.workers }()
+		m.execute(job.ID, work)
+	}()
+
+	return job, nil
+}
+
+// Run processes work for the upload in the calling goroutine and returns
+// the finished job.
+func (m *Manager) Run(upload Upload, work func(id string) Outcome) (Job, error) {
+	job, err := m.create(upload)
+	if err != nil {
+		return Job{}, err
+	}
+	return m.execute(job.ID, work), nil
+}
+
+func (m *Manager) create(upload Upload) (Job, error) {
+	id, err := newID()
+	if err != nil {
+		return Job{}, err
+	}
+
+	job := &Job{
+		ID:        id,
+		Upload:    upload,
+		State:     Queued,
+		CreatedAt: time.Now(),
+	}
+
+	m.mu.Lock()
+	m.jobs[id] = job
+	m.mu.Unlock()
+
+	return m.persist(id), nil
+}
+
+func (m *Manager) execute(id string, work func(id string) Outcome) Job {
+	m.update(id, func(job *Job) {
+		now := time.Now()
+		job.State = Running
+		job.StartedAt = &now
+	})
+	m.persist(id)
+
+	outcome := work(id)
+
+	m.update(id, func(job *Job) {
+		now := time.Now()
+		job.FinishedAt = &now
+		job.ProcessedRows = outcome.ProcessedRows
+		job.FailedRows = outcome.FailedRows
+		job.Batches = outcome.Batches
+		job.PerRow = outcome.PerRow
+		job.RowErrors = outcome.RowErrors
+		job.RejectedURL = outcome.RejectedURL
+		if outcome.Err != nil {
+			job.State = Failed
+			job.Errors = append(job.Errors, outcome.Err.Error())
+		} else {
+			job.State = Succeeded
+		}
+	})
+	job := m.persist(id)
+
+	// Finished jobs are served from the store once they are persisted
+	m.mu.Lock()
+	if m.store != nil {
+		delete(m.jobs, id)
+	} else {
+		m.finished = append(m.finished, id)
+		m.evict()
+	}
+	m.mu.Unlock()
+	return job
+}
+
+// evict drops the oldest finished jobs and their rejected rows beyond
+// maxFinishedJobs. m.mu must be held.
+func (m *Manager) evict() {
+	for len(m.finished) > maxFinishedJobs {
+		id := m.finished[0]
+		m.finished = m.finished[1:]
+		delete(m.jobs, id)
+		os.Remove(m.RejectedPath(id))
+	}
+}
+
+// persist saves the current state of the job to the store, if any, and
+// returns a copy of it.
+func (m *Manager) persist(id string) Job {
+	m.mu.RLock()
+	job := *m.jobs[id]
+	m.mu.RUnlock()
+
+	if m.store != nil {
+		if err := m.store.Save(job); err != nil {
+			log.Printf("Failed to persist job %s: %v", id, err)
+		}
+	}
+	return job
+}
+
+// Progress updates the processed row count of a running job.
+func (m *Manager) Progress(id string, processedRows int) {
+	m.update(id, func(job *Job) {
+		job.ProcessedRows = processedRows
+	})
+}
+
+// Get returns a copy of the job with the given ID.
+func (m *Manager) Get(id string) (Job, bool, error) {
+	m.mu.RLock()
+	job, exists := m.jobs[id]
+	var snapshot Job
+	if exists {
+		snapshot = *job
+	}
+	m.mu.RUnlock()
+
+	if exists {
+		return snapshot, true, nil
+	}
+	if m.store != nil {
+		return m.store.Get(id)
+	}
+	return Job{}, false, nil
+}
+
+// List returns the jobs matching the filter, newest first.
+func (m *Manager) List(filter Filter) ([]Job, error) {
+	m.mu.RLock()
+	active := make(map[string]Job, len(m.jobs))
+	for id, job := range m.jobs {
+		if filter.Match(*job) {
+			active[id] = *job
+		}
+	}
+	m.mu.RUnlock()
+
+	jobs := []Job{}
+	if m.store != nil {
+		stored, err := m.store.List(filter)
+		if err != nil {
+			return nil, err
+		}
+		for _, job := range stored {
+			// Active jobs have fresher progress than their stored copy
+			if _, exists := active[job.ID]; !exists {
+				jobs = append(jobs, job)
+			}
+		}
+	}
+	for _, job := range active {
+		jobs = append(jobs, job)
+	}
+	sortJobs(jobs)
+	return jobs, nil
+}
+
+// StartRetention removes jobs older than retention once at startup and then
+// every hour.
+func (m *Manager) StartRetention(retention time.Duration) {
+	if retention <= 0 {
+		return
+	}
+	go func() {
+		for {
+			m.prune(time.Now().Add(-retention))
+			time.Sleep(time.Hour)
+		}
+	}()
+}
+
+func (m *Manager) prune(before time.Time) {
+	var deleted []string
+	m.mu.Lock()
+	for id, job := range m.jobs {
+		if job.FinishedAt != nil && job.CreatedAt.Before(before) {
+			delete(m.jobs, id)
+			deleted = append(deleted, id)
+		}
+	}
+	finished := m.finished[:0]
+	for _, id := range m.finished {
+		if _, exists := m.jobs[id]; exists {
+			finished = append(finished, id)
+		}
+	}
+	m.finished = finished
+	m.mu.Unlock()
+
+	if m.store != nil {
+		stored, err := m.store.DeleteBefore(before)
+		if err != nil {
+			log.Printf("Failed to prune job store: %v", err)
+		}
+		deleted = append(deleted, stored...)
+	}
+
+	for _, id := range deleted {
+		os.Remove(m.RejectedPath(id))
+	}
+	if len(deleted) > 0 {
+		log.Printf("Pruned %d jobs created before %s", len(deleted), before.Format(time.RFC3339))
+	}
+}
+
+func (m *Manager) update(id string, fn func(job *Job)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if job, exists := m.jobs[id]; exists {
+		fn(job)
+	}
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate job id: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,140 @@
+package jobs
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var jobsBucket = []byte("jobs")
+
+// Store persists jobs in an embedded bbolt database
+type Store struct {
+	db *bolt.DB
+}
+
+func OpenStore(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open job store %s: %w", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(jobsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize job store: %w", err)
+	}
+
+	return &Store{db: db}, nil
+}
+
+func (s *Store) Save(job Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("failed to serialize job %s: %w", job.ID, err)
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).Put([]byte(job.ID), data)
+	})
+}
+
+func (s *Store) Get(id string) (Job, bool, error) {
+	var job Job
+	found := false
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(jobsBucket).Get([]byte(id))
+		if data == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(data, &job)
+	})
+	return job, found, err
+}
+
+// List returns the jobs matching the filter, newest first.
+func (s *Store) List(filter Filter) ([]Job, error) {
+	jobs := []Job{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).ForEach(func(_, data []byte) error {
+			var job Job
+			if err := json.Unmarshal(data, &job); err != nil {
+				return err
+			}
+			if filter.Match(job) {
+				jobs = append(jobs, job)
+			}
+			return nil
+		})
+	})
+	sortJobs(jobs)
+	return jobs, err
+}
+
+// DeleteBefore removes all jobs created before t and returns their IDs.
+func (s *Store) DeleteBefore(t time.Time) ([]string, error) {
+	var deleted []string
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(jobsBucket)
+		var expired [][]byte
+		err := bucket.ForEach(func(key, data []byte) error {
+			var job Job
+			if err := json.Unmarshal(data, &job); err != nil {
+				return err
+			}
+			if job.CreatedAt.Before(t) {
+				expired = append(expired, append([]byte(nil), key...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, key := range expired {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+		for _, key := range expired {
+			deleted = append(deleted, string(key))
+		}
+		return nil
+	})
+	return deleted, err
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Filter selects jobs when listing
+type Filter struct {
+	RuleID string
+	State  State
+	Since  time.Time
+}
+
+func (f Filter) Match(job Job) bool {
+	if f.RuleID != "" && job.RuleID != f.RuleID {
+		return false
+	}
+	if f.State != "" && job.State != f.State {
+		return false
+	}
+	if !f.Since.IsZero() && job.CreatedAt.Before(f.Since) {
+		return false
+	}
+	return true
+}
+
+func sortJobs(jobs []Job) {
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.After(jobs[j].CreatedAt)
+	})
+}
@@ -0,0 +1,118 @@
+package mapping
+
+import (
+	"datenkarte/internal/models"
+	"datenkarte/internal/validation"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	defaultTrueValues  = []string{"true", "1", "yes", "y"}
+	defaultFalseValues = []string{"false", "0", "no", "n"}
+)
+
+// coerceValue converts a mapped value to the mapping's type. Empty values are
+// never converted but replaced according to empty_as; omit reports false to
+// leave the field out of the payload.
+func coerceValue(mapping models.Mapping, value interface{}) (interface{}, bool, error) {
+	s := stringOf(value)
+	if value == nil || s == "" {
+		switch mapping.EmptyAs {
+		case "", `""`:
+			return value, true, nil
+		case "null":
+			return nil, true, nil
+		case "omit":
+			return nil, false, nil
+		default:
+			return nil, false, fmt.Errorf("unknown empty_as: %s", mapping.EmptyAs)
+		}
+	}
+
+	header := mapping.Name
+	switch mapping.Type {
+	case "":
+		return value, true, nil
+	case "string":
+		return s, true, nil
+	case "integer":
+		n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+		if err != nil {
+			return nil, false, fmt.Errorf("field %s must be an integer, got: %s", header, s)
+		}
+		return n, true, nil
+	case "float":
+		n, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+			return nil, false, fmt.Errorf("field %s must be a number, got: %s", header, s)
+		}
+		return n, true, nil
+	case "decimal":
+		// Kept as a string so no precision is lost on the way
+		s = strings.TrimSpace(s)
+		if !validation.DecimalPattern.MatchString(s) {
+			return nil, false, fmt.Errorf("field %s must be a decimal number like 12.50, got: %s", header, s)
+		}
+		return s, true, nil
+	case "boolean":
+		if b, ok := value.(bool); ok {
+			return b, true, nil
+		}
+		trueValues, falseValues := mapping.TrueValues, mapping.FalseValues
+		if len(trueValues) == 0 {
+			trueValues = defaultTrueValues
+		}
+		if len(falseValues) == 0 {
+			falseValues = defaultFalseValues
+		}
+		if containsFold(trueValues, s) {
+			return true, true, nil
+		}
+		if containsFold(falseValues, s) {
+			return false, true, nil
+		}
+		return nil, false, fmt.Errorf("field %s must be a boolean, got: %s", header, s)
+	case "date":
+		layout := mapping.Layout
+		if layout == "" {
+			layout = validation.DefaultDateLayout
+		}
+		format := mapping.Format
+		if format == "" {
+			format = validation.DefaultDateLayout
+		}
+		t, err := time.Parse(layout, strings.TrimSpace(s))
+		if err != nil {
+			return nil, false, fmt.Errorf("field %s must be a date in layout %s, got: %s", header, layout, s)
+		}
+		return t.Format(format), true, nil
+	default:
+		return nil, false, fmt.Errorf("unknown type: %s for field %s", mapping.Type, header)
+	}
+}
+
+func stringOf(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+func containsFold(list []string, value string) bool {
+	value = strings.TrimSpace(value)
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
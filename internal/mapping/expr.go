@@ -0,0 +1,40 @@
+package mapping
+
+import (
+	"datenkarte/internal/expr"
+	"datenkarte/internal/models"
+	"datenkarte/internal/validation"
+	"fmt"
+)
+
+// CompileExpressions compiles the expressions of all mappings, so invalid
+// ones are reported at startup instead of failing every row.
+func CompileExpressions(rules []models.Rule) error {
+	for _, rule := range rules {
+		for _, eachLine := range rule.EachLine {
+			for _, mapping := range eachLine.Map {
+				if mapping.Expr == "" {
+					continue
+				}
+				if _, err := expr.Compile(mapping.Expr); err != nil {
+					return fmt.Errorf("rule %s, mapping %s: %v", rule.ID, mapping.Name, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// evalExpr evaluates a mapping expression against the row and the fields
+// mapped so far.
+func evalExpr(source string, line []string, headers []string, mapped map[string]interface{}, index int) (interface{}, error) {
+	program, err := expr.Compile(source)
+	if err != nil {
+		return nil, err
+	}
+	return program.Eval(expr.Env{
+		Row:    validation.NewRow(line, headers),
+		Mapped: mapped,
+		Index:  index,
+	})
+}
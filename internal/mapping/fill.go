@@ -0,0 +1,95 @@
+package mapping
+
+import (
+	"datenkarte/internal/models"
+	"fmt"
+	"strconv"
+)
+
+// fillValue resolves the value of a fill block for the row at index (0-based).
+// The special values "row_number" and "row_index" are replaced with the
+// 1-based and 0-based position of the row in the file.
+func fillValue(fill *models.Fill, index int) (interface{}, error) {
+	value := fill.Value
+	if s, ok := value.(string); ok {
+		switch s {
+		case "row_number":
+			value = index + 1
+		case "row_index":
+			value = index
+		}
+	}
+
+	switch fill.Type {
+	case "", "string":
+		if fill.Type == "" && fill.Prefix == "" && fill.Suffix == "" {
+			return copyValue(value), nil
+		}
+		if value == nil {
+			value = ""
+		}
+		return fill.Prefix + fmt.Sprint(value) + fill.Suffix, nil
+	case "number":
+		switch v := value.(type) {
+		case int, int64, float64:
+			return v, nil
+		case string:
+			if n, err := strconv.Atoi(v); err == nil {
+				return n, nil
+			}
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				return f, nil
+			}
+		}
+		return nil, fmt.Errorf("fill value %v is not a number", value)
+	case "boolean":
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case string:
+			if b, err := strconv.ParseBool(v); err == nil {
+				return b, nil
+			}
+		}
+		return nil, fmt.Errorf("fill value %v is not a boolean", value)
+	case "array":
+		if value == nil {
+			return []interface{}{}, nil
+		}
+		if v, ok := value.([]interface{}); ok {
+			return copyValue(v), nil
+		}
+		return []interface{}{copyValue(value)}, nil
+	case "object":
+		if value == nil {
+			return map[string]interface{}{}, nil
+		}
+		if v, ok := value.(map[string]interface{}); ok {
+			return copyValue(v), nil
+		}
+		return nil, fmt.Errorf("fill value %v is not an object", value)
+	default:
+		return nil, fmt.Errorf("unknown fill type: %s", fill.Type)
+	}
+}
+
+// copyValue deep copies arrays and objects so rows never share state with the
+// configuration or with each other.
+func copyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case []interface{}:
+		c := make([]interface{}, len(v))
+		for i, item := range v {
+			c[i] = copyValue(item)
+		}
+		return c
+	case map[string]interface{}:
+		c := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			c[k] = copyValue(item)
+		}
+		return c
+	default:
+		return v
+	}
+}
@@ -0,0 +1,42 @@
+package mapping
+
+import (
+	"datenkarte/internal/models"
+	"fmt"
+)
+
+// MergeGroup adds the payload of another row of the same group to group:
+// its items are appended and fields the group does not have yet are added.
+func MergeGroup(group map[string]interface{}, payload map[string]interface{}, rule models.Rule) error {
+	into := GroupInto(rule)
+	existing, _ := getPath(group, into)
+	items, ok := existing.([]interface{})
+	if !ok {
+		return fmt.Errorf("group_into target %s is not an array", into)
+	}
+	if more, ok := getPath(payload, into); ok {
+		if more, ok := more.([]interface{}); ok {
+			items = append(items, more...)
+		}
+	}
+
+	mergeMissing(group, payload)
+	return setPath(group, into, items)
+}
+
+// mergeMissing copies the fields of src missing in dst, descending into
+// objects present in both.
+func mergeMissing(dst map[string]interface{}, src map[string]interface{}) {
+	for key, value := range src {
+		existing, exists := dst[key]
+		if !exists {
+			dst[key] = value
+			continue
+		}
+		if d, ok := existing.(map[string]interface{}); ok {
+			if s, ok := value.(map[string]interface{}); ok {
+				mergeMissing(d, s)
+			}
+		}
+	}
+}
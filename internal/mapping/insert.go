@@ -0,0 +1,88 @@
+package mapping
+
+import (
+	"datenkarte/internal/models"
+	"fmt"
+	"strings"
+)
+
+type insert struct {
+	target  map[string]interface{}
+	mapping models.Mapping
+	value   interface{}
+}
+
+// insertInto adds value to the field at mapping.InsertInto. Arrays get the
+// value appended, objects get it set under the mapping's target key and
+// missing targets are created as a new array.
+func insertInto(mapped map[string]interface{}, mapping models.Mapping, value interface{}) error {
+	if s, ok := value.(string); ok && s == "" {
+		return nil
+	}
+
+	target, exists := getPath(mapped, mapping.InsertInto)
+	if !exists {
+		return setPath(mapped, mapping.InsertInto, []interface{}{value})
+	}
+
+	switch t := target.(type) {
+	case []interface{}:
+		return setPath(mapped, mapping.InsertInto, append(t, value))
+	case map[string]interface{}:
+		key := mapping.To
+		if key == "" {
+			key = mapping.Name
+		}
+		t[key] = value
+		return nil
+	default:
+		return fmt.Errorf("insert_into target %s is neither an array nor an object", mapping.InsertInto)
+	}
+}
+
+// getPath returns the value at the dot separated path in m.
+func getPath(m map[string]interface{}, path string) (interface{}, bool) {
+	parts := strings.Split(path, ".")
+	current := m
+	for i, part := range parts {
+		value, exists := current[part]
+		if !exists {
+			return nil, false
+		}
+		if i == len(parts)-1 {
+			return value, true
+		}
+		next, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		current = next
+	}
+	return nil, false
+}
+
+// setPath sets the value at the dot separated path in m, creating
+// intermediate objects as needed.
+func setPath(m map[string]interface{}, path string, value interface{}) error {
+	parts := strings.Split(path, ".")
+	current := m
+	for i, part := range parts {
+		if i == len(parts)-1 {
+			current[part] = value
+			return nil
+		}
+		next, exists := current[part]
+		if !exists {
+			nested := make(map[string]interface{})
+			current[part] = nested
+			current = nested
+			continue
+		}
+		nested, ok := next.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("cannot set %s: %s is not an object", path, strings.Join(parts[:i+1], "."))
+		}
+		current = nested
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package mapping
+
+import (
+	"datenkarte/internal/models"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+type lookupTable struct {
+	config models.Lookup
+	values map[string]interface{}
+}
+
+var (
+	lookups   = make(map[string]*lookupTable)
+	lookupsMu sync.RWMutex
+)
+
+// LoadLookups prepares the lookup tables of the configuration. Tables backed
+// by a file are read once; inline values override values from the file.
+func LoadLookups(configs []models.Lookup) error {
+	tables := make(map[string]*lookupTable, len(configs))
+	for _, config := range configs {
+		if config.Name == "" {
+			return fmt.Errorf("lookup without name")
+		}
+		if _, exists := tables[config.Name]; exists {
+			return fmt.Errorf("duplicate lookup: %s", config.Name)
+		}
+
+		values := make(map[string]interface{})
+		if config.File != "" {
+			loaded, err := loadLookupFile(config)
+			if err != nil {
+				return fmt.Errorf("failed to load lookup %s: %v", config.Name, err)
+			}
+			values = loaded
+		}
+		for key, value := range config.Values {
+			values[key] = value
+		}
+
+		table := &lookupTable{config: config, values: make(map[string]interface{}, len(values))}
+		for key, value := range values {
+			table.values[table.key(key)] = value
+		}
+		tables[config.Name] = table
+	}
+
+	lookupsMu.Lock()
+	lookups = tables
+	lookupsMu.Unlock()
+	return nil
+}
+
+// CheckLookups reports mappings referring to a lookup table that is not
+// loaded, so they fail at startup instead of on every row.
+func CheckLookups(rules []models.Rule) error {
+	lookupsMu.RLock()
+	defer lookupsMu.RUnlock()
+
+	for _, rule := range rules {
+		for _, eachLine := range rule.EachLine {
+			for _, mapping := range eachLine.Map {
+				if _, exists := lookups[mapping.Lookup]; mapping.Lookup != "" && !exists {
+					return fmt.Errorf("rule %s, mapping %s: unknown lookup: %s", rule.ID, mapping.Name, mapping.Lookup)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (t *lookupTable) key(code string) string {
+	code = strings.TrimSpace(code)
+	if t.config.IgnoreCase {
+		return strings.ToLower(code)
+	}
+	return code
+}
+
+// loadLookupFile reads a CSV file with a header line, using the key and value
+// columns (default the first and second), or a JSON file holding either an
+// object of codes or an array of objects with key and value fields. Without a
+// value field the whole object becomes the value.
+func loadLookupFile(config models.Lookup) (map[string]interface{}, error) {
+	file, err := os.Open(config.File)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	values := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(config.File)) {
+	case ".csv":
+		reader := csv.NewReader(file)
+		reader.Comma = ';'
+		if config.Delimiter != "" {
+			reader.Comma = []rune(config.Delimiter)[0]
+		}
+		records, err := reader.ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		if len(records) == 0 {
+			return values, nil
+		}
+
+		keyColumn, valueColumn := 0, 1
+		if config.Key != "" {
+			keyColumn = indexOf(records[0], config.Key)
+		}
+		if config.Value != "" {
+			valueColumn = indexOf(records[0], config.Value)
+		}
+		if keyColumn < 0 || valueColumn < 0 || valueColumn >= len(records[0]) {
+			return nil, fmt.Errorf("key or value column not found in %s", config.File)
+		}
+
+		for _, record := range records[1:] {
+			values[record[keyColumn]] = record[valueColumn]
+		}
+	case ".json":
+		var data interface{}
+		if err := json.NewDecoder(file).Decode(&data); err != nil {
+			return nil, err
+		}
+		switch data := data.(type) {
+		case map[string]interface{}:
+			return data, nil
+		case []interface{}:
+			if config.Key == "" {
+				return nil, fmt.Errorf("key is required for a JSON array")
+			}
+			for _, item := range data {
+				object, ok := item.(map[string]interface{})
+				if !ok {
+					return nil, fmt.Errorf("JSON array must contain objects")
+				}
+				var value interface{} = object
+				if config.Value != "" {
+					value = object[config.Value]
+				}
+				values[stringOf(object[config.Key])] = value
+			}
+		default:
+			return nil, fmt.Errorf("JSON must be an object or an array")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported lookup file %s, expected .csv or .json", config.File)
+	}
+	return values, nil
+}
+
+func indexOf(list []string, search string) int {
+	for i, item := range list {
+		if item == search {
+			return i
+		}
+	}
+	return -1
+}
+
+// lookupValue translates the value with the mapping's lookup table. Empty
+// values are kept, unknown codes fail in strict mode and otherwise become the
+// table's default or stay unchanged.
+func lookupValue(mapping models.Mapping, value interface{}) (interface{}, error) {
+	lookupsMu.RLock()
+	table, exists := lookups[mapping.Lookup]
+	lookupsMu.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("unknown lookup: %s", mapping.Lookup)
+	}
+
+	code := stringOf(value)
+	if code == "" {
+		return value, nil
+	}
+	if translated, exists := table.values[table.key(code)]; exists {
+		return copyValue(translated), nil
+	}
+	if table.config.Strict {
+		return nil, fmt.Errorf("field %s has unknown code %s in lookup %s", mapping.Name, code, mapping.Lookup)
+	}
+	if table.config.Default != nil {
+		return copyValue(table.config.Default), nil
+	}
+	return value, nil
+}
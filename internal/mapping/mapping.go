@@ -4,6 +4,7 @@ import (
 	"datenkarte/internal/handlers"
 	"datenkarte/internal/models"
 	"datenkarte/internal/plugins"
+	"datenkarte/internal/validation"
 	"fmt"
 	"log"
 	"strings"
@@ -18,6 +19,54 @@ func stringInSlice(search string, list []string) bool {
 	return false
 }
 
+// columnOf returns the index of the header in the line, or -1.
+func columnOf(name string, headers []string, line []string) int {
+	for i, header := range headers {
+		if name == header && i < len(line) {
+			return i
+		}
+	}
+	return -1
+}
+
+// convertValue applies the mapping's lookup and type to the value, or to
+// each element of arrays built by split and from.
+func convertValue(mapping models.Mapping, value interface{}) (interface{}, bool, error) {
+	if list, ok := value.([]interface{}); ok && (mapping.Split != nil || len(mapping.From) > 0) {
+		converted := make([]interface{}, 0, len(list))
+		for _, element := range list {
+			element, keep, err := convertElement(mapping, element)
+			if err != nil {
+				return nil, false, err
+			}
+			if keep {
+				converted = append(converted, element)
+			}
+		}
+		return converted, true, nil
+	}
+	return convertElement(mapping, value)
+}
+
+func convertElement(mapping models.Mapping, value interface{}) (interface{}, bool, error) {
+	if mapping.Lookup != "" {
+		translated, err := lookupValue(mapping, value)
+		if err != nil {
+			return nil, false, err
+		}
+		value = translated
+	}
+	return coerceValue(mapping, value)
+}
+
+// GroupInto returns the field collecting the item mappings of grouped rows.
+func GroupInto(rule models.Rule) string {
+	if rule.GroupInto == "" {
+		return "items"
+	}
+	return rule.GroupInto
+}
+
 func MapLineToJSON(line []string, headers []string, rule models.Rule, index int, pm *plugins.PluginManager) (map[string]interface{}, error) {
 	// Execute ENTER_LINE hook for all plugins
 	data := map[string]interface{}{
@@ -40,15 +89,28 @@ func MapLineToJSON(line []string, headers []string, rule models.Rule, index int,
 	}
 	headers = normalizedHeaders
 
+	// Every each_line block applying to the row maps into the same payload
+	blocks := validation.EachLines(rule, validation.NewRow(line, headers))
+	if len(blocks) == 0 {
+		return nil, fmt.Errorf("no each_line block applies to the row")
+	}
+	var mappings []models.Mapping
+	for _, eachLine := range blocks {
+		mappings = append(mappings, eachLine.Map...)
+	}
+
 	mapped := make(map[string]interface{})
-	for _, mapping := range rule.EachLine[0].Map {
+	// Item mappings of grouped rules go into the row's entry of the group
+	item := make(map[string]interface{})
+	var inserts []insert
+	for _, mapping := range mappings {
 		found := false
 		targetKey := mapping.To
 		if targetKey == "" {
 			targetKey = mapping.Name
 		}
 
-		if mapping.Required {
+		if mapping.Required && mapping.Expr == "" && len(mapping.From) == 0 {
 			ok := stringInSlice(mapping.Name, headers)
 			if !ok {
 				return nil, fmt.Errorf("required header not found: %s", mapping.Name)
@@ -56,13 +118,48 @@ func MapLineToJSON(line []string, headers []string, rule models.Rule, index int,
 		}
 
 		var value interface{}
+		if mapping.Expr != "" {
+			// Expressions see the row and everything mapped before them
+			result, err := evalExpr(mapping.Expr, line, headers, mapped, index)
+			if err != nil {
+				return nil, fmt.Errorf("expr failed for %s: %w", targetKey, err)
+			}
+			found = true
+			value = result
+		} else if len(mapping.From) > 0 {
+			// Several columns are collected into one array, skipping empty cells
+			values := []interface{}{}
+			for _, name := range mapping.From {
+				if column := columnOf(name, headers, line); column >= 0 {
+					found = true
+					if line[column] != "" {
+						values = append(values, line[column])
+					}
+				}
+			}
+			if found {
+				value = values
+			}
+		} else if column := columnOf(mapping.Name, headers, line); column >= 0 {
+			found = true
+			value = line[column]
+		}
 
-		for i, header := range headers {
-			if mapping.Name != header {
-				continue
+		if mapping.Fill != nil && (!found || value == "") {
+			filled, err := fillValue(mapping.Fill, index)
+			if err != nil {
+				return nil, fmt.Errorf("fill failed for %s: %w", mapping.Name, err)
 			}
 			found = true
-			value = line[i]
+			value = filled
+		}
+
+		if found && mapping.Split != nil {
+			value = splitValue(mapping.Split, value)
+		}
+
+		if found {
+			header := mapping.Name
 
 			// Execute plugins for this field if any
 			if len(mapping.Plugins) > 0 {
@@ -86,26 +183,6 @@ func MapLineToJSON(line []string, headers []string, rule models.Rule, index int,
 				}
 			}
 
-			// Handle nested mapping
-			if mapping.Nested != "" {
-				nestedParts := strings.Split(mapping.Nested, ".")
-				current := mapped
-				for j, part := range nestedParts {
-					if j == len(nestedParts)-1 {
-						current[part] = value
-					} else {
-						if _, exists := current[part]; !exists {
-							current[part] = make(map[string]interface{})
-						}
-						current = current[part].(map[string]interface{})
-					}
-				}
-			} else {
-				if mapping.InsertInto == "" {
-					mapped[targetKey] = value
-				}
-			}
-
 			// Execute handlers after plugins
 			for _, handler := range mapping.Handlers {
 				response, err := handlers.SendCommand(handler, value)
@@ -113,7 +190,33 @@ func MapLineToJSON(line []string, headers []string, rule models.Rule, index int,
 					log.Printf("%v\n", err)
 					continue
 				}
-				mapped[targetKey] = response
+				value = response
+			}
+
+			converted, keep, err := convertValue(mapping, value)
+			if err != nil {
+				return nil, err
+			}
+			if !keep {
+				continue
+			}
+			value = converted
+
+			target := mapped
+			if mapping.Item && len(rule.GroupBy) > 0 {
+				target = item
+			}
+
+			// Inserts run after all other mappings so their targets exist
+			// regardless of declaration order
+			if mapping.InsertInto != "" {
+				inserts = append(inserts, insert{target: target, mapping: mapping, value: value})
+			} else if mapping.Nested != "" {
+				if err := setPath(target, mapping.Nested, value); err != nil {
+					return nil, err
+				}
+			} else {
+				target[targetKey] = value
 			}
 		}
 
@@ -122,6 +225,22 @@ func MapLineToJSON(line []string, headers []string, rule models.Rule, index int,
 		}
 	}
 
+	for _, ins := range inserts {
+		if err := insertInto(ins.target, ins.mapping, ins.value); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(rule.GroupBy) > 0 {
+		items := []interface{}{}
+		if len(item) > 0 {
+			items = append(items, item)
+		}
+		if err := setPath(mapped, GroupInto(rule), items); err != nil {
+			return nil, err
+		}
+	}
+
 	// Execute EXIT_LINE hook
 	exitData := map[string]interface{}{
 		"mapped":  mapped,
@@ -0,0 +1,47 @@
+package mapping
+
+import (
+	"datenkarte/internal/models"
+	"strings"
+)
+
+// splitValue splits a string into an array at the separator (default ",").
+// Arrays, e.g. from several columns, have each element split and flattened.
+func splitValue(split *models.Split, value interface{}) interface{} {
+	separator := split.Separator
+	if separator == "" {
+		separator = ","
+	}
+
+	var cells []interface{}
+	switch v := value.(type) {
+	case string:
+		cells = []interface{}{v}
+	case []interface{}:
+		cells = v
+	default:
+		return value
+	}
+
+	parts := []interface{}{}
+	for _, cell := range cells {
+		s, ok := cell.(string)
+		if !ok {
+			parts = append(parts, cell)
+			continue
+		}
+		if s == "" {
+			continue
+		}
+		for _, part := range strings.Split(s, separator) {
+			if split.Trim {
+				part = strings.TrimSpace(part)
+			}
+			if part == "" && split.DropEmpty {
+				continue
+			}
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
@@ -1,6 +1,9 @@
 package models
 
-import "datenkarte/internal/handlers"
+import (
+	"datenkarte/internal/handlers"
+	"time"
+)
 
 // AuthHeader defines a single authentication header
 type AuthHeader struct {
@@ -33,23 +36,85 @@ type Mapping struct {
 	Nested     string   `yaml:"nested"`
 	Fill       *Fill    `yaml:"fill"`
 	InsertInto string   `yaml:"insert_into"`
+	Expr       string   `yaml:"expr"`
 	Handlers   []string `yaml:"handlers"`
 	Plugins    []string `yaml:"plugins"`
+	Item       bool     `yaml:"item"`
+	Lookup     string   `yaml:"lookup"`
+	Split      *Split   `yaml:"split"`
+	From       []string `yaml:"from"`
+
+	Aliases       []string `yaml:"aliases"`
+	HeaderPattern string   `yaml:"header_pattern"`
+	Column        int      `yaml:"column"`
+
+	Type        string   `yaml:"type"`
+	Layout      string   `yaml:"layout"`
+	Format      string   `yaml:"format"`
+	TrueValues  []string `yaml:"true_values"`
+	FalseValues []string `yaml:"false_values"`
+	EmptyAs     string   `yaml:"empty_as"`
 }
+
+// Fill defines the value used when the CSV column is missing or empty
 type Fill struct {
 	Type   string      `yaml:"type"`
 	Value  interface{} `yaml:"value"`
 	Prefix string      `yaml:"prefix,omitempty"`
+	Suffix string      `yaml:"suffix,omitempty"`
 }
 
+// Split defines how a cell is split into an array
+type Split struct {
+	Separator string `yaml:"separator"`
+	Trim      bool   `yaml:"trim"`
+	DropEmpty bool   `yaml:"drop_empty"`
+}
+
+// Validation defines a check of a single field
 type Validation struct {
-	Field   string `yaml:"field"`
-	Type    string `yaml:"type"`
-	Pattern string `yaml:"pattern,omitempty"`
+	Field      string      `yaml:"field"`
+	Type       string      `yaml:"type"`
+	Pattern    string      `yaml:"pattern,omitempty"`
+	Layout     string      `yaml:"layout,omitempty"`
+	Min        *float64    `yaml:"min,omitempty"`
+	Max        *float64    `yaml:"max,omitempty"`
+	MinLength  *int        `yaml:"min_length,omitempty"`
+	MaxLength  *int        `yaml:"max_length,omitempty"`
+	Values     []string    `yaml:"values,omitempty"`
+	IgnoreCase bool        `yaml:"ignore_case,omitempty"`
+	Optional   bool        `yaml:"optional,omitempty"`
+	Other      string      `yaml:"other,omitempty"`
+	Value      string      `yaml:"value,omitempty"`
+	Fields     []string    `yaml:"fields,omitempty"`
+	When       []Condition `yaml:"when,omitempty"`
+}
+
+// Condition matches a row by the value of one of its fields. All set
+// options must match.
+type Condition struct {
+	Field     string   `yaml:"field"`
+	Equals    *string  `yaml:"equals,omitempty"`
+	NotEquals *string  `yaml:"not_equals,omitempty"`
+	In        []string `yaml:"in,omitempty"`
+	Empty     *bool    `yaml:"empty,omitempty"`
+	Matches   string   `yaml:"matches,omitempty"`
+}
+
+// FileValidation defines checks of the whole file, evaluated before any
+// payload is delivered
+type FileValidation struct {
+	RequiredHeaders []string `yaml:"required_headers"`
+	NoExtraColumns  bool     `yaml:"no_extra_columns"`
+	Unique          []string `yaml:"unique"`
+	MinRows         int      `yaml:"min_rows"`
+	MaxRows         int      `yaml:"max_rows"`
 }
 
-// EachLine defines the configuration for a single processing line
+// EachLine defines the configuration for a single processing line. Blocks
+// with when conditions only apply to matching rows.
 type EachLine struct {
+	When       []Condition  `yaml:"when"`
 	Map        []Mapping    `yaml:"map"`
 	Validation []Validation `yaml:"validation"`
 }
@@ -59,21 +124,112 @@ type HttpAuth struct {
 	Value string `yaml:"value"`
 }
 
+// Retry defines how failed HTTP requests are retried
+type Retry struct {
+	MaxAttempts int           `yaml:"max_attempts"`
+	BaseBackoff time.Duration `yaml:"base_backoff"`
+	MaxBackoff  time.Duration `yaml:"max_backoff"`
+	Jitter      float64       `yaml:"jitter"`
+	Statuses    []int         `yaml:"statuses"`
+}
+
 type HttpType struct {
-	Url        string       `yaml:"url"`
-	Method     string       `yaml:"method"`
-	Headers    []HttpHeader `yaml:"headers"`
-	Auth       *HttpAuth    `yaml:"auth"`
-	PayloadKey string       `yaml:"payload_key"`
+	Url            string        `yaml:"url"`
+	Method         string        `yaml:"method"`
+	Headers        []HttpHeader  `yaml:"headers"`
+	Auth           *HttpAuth     `yaml:"auth"`
+	PayloadKey     string        `yaml:"payload_key"`
+	BatchSize      int           `yaml:"batch_size"`
+	MaxBytes       int           `yaml:"max_bytes"`
+	Mode           string        `yaml:"mode"`
+	Retry          *Retry        `yaml:"retry"`
+	Timeout        time.Duration `yaml:"timeout"`
+	ConnectTimeout time.Duration `yaml:"connect_timeout"`
 }
 
 // Rule defines the processing rules for an endpoint
 type Rule struct {
-	ID        string     `yaml:"id"`
-	Delimiter string     `yaml:"delimiter"`
-	Type      string     `yaml:"type"`
-	Http      *HttpType  `yaml:"http"`
-	EachLine  []EachLine `yaml:"each_line"`
+	ID                string         `yaml:"id"`
+	Delimiter         string         `yaml:"delimiter"`
+	Quote             string         `yaml:"quote"`
+	LazyQuotes        bool           `yaml:"lazy_quotes"`
+	Comment           string         `yaml:"comment"`
+	TrimLeadingSpace  bool           `yaml:"trim_leading_space"`
+	VariableFields    bool           `yaml:"variable_fields"`
+	Format            string         `yaml:"format"`
+	Encoding          string         `yaml:"encoding"`
+	Type              string         `yaml:"type"`
+	Http              *HttpType      `yaml:"http"`
+	EachLine          []EachLine     `yaml:"each_line"`
+	Async             bool           `yaml:"async"`
+	OnInvalid         string         `yaml:"on_invalid"`
+	File              FileValidation `yaml:"file"`
+	Headerless        bool           `yaml:"headerless"`
+	IgnoreCaseHeaders bool           `yaml:"ignore_case_headers"`
+	Excel             Excel          `yaml:"excel"`
+	FixedWidth        FixedWidth     `yaml:"fixed_width"`
+	GroupBy           []string       `yaml:"group_by"`
+	GroupMode         string         `yaml:"group_mode"`
+	GroupInto         string         `yaml:"group_into"`
+}
+
+// Lookup defines a table translating codes to values, either inline or
+// loaded from a CSV or JSON file
+type Lookup struct {
+	Name       string                 `yaml:"name"`
+	Values     map[string]interface{} `yaml:"values"`
+	File       string                 `yaml:"file"`
+	Key        string                 `yaml:"key"`
+	Value      string                 `yaml:"value"`
+	Delimiter  string                 `yaml:"delimiter"`
+	Default    interface{}            `yaml:"default"`
+	Strict     bool                   `yaml:"strict"`
+	IgnoreCase bool                   `yaml:"ignore_case"`
+}
+
+// Excel defines how .xlsx uploads are read
+type Excel struct {
+	Sheet      string `yaml:"sheet"`
+	SheetIndex int    `yaml:"sheet_index"`
+	HeaderRow  int    `yaml:"header_row"`
+	Raw        bool   `yaml:"raw"`
+	DateLayout string `yaml:"date_layout"`
+}
+
+// FixedWidth defines the layout of fixed-width text uploads. Files with
+// several record types select the columns of a line by its record_type.
+type FixedWidth struct {
+	RecordType *FixedColumn  `yaml:"record_type"`
+	Records    []FixedRecord `yaml:"records"`
+	Columns    []FixedColumn `yaml:"columns"`
+	Trim       string        `yaml:"trim"`
+	Pad        string        `yaml:"pad"`
+}
+
+// FixedRecord defines the columns of one record type
+type FixedRecord struct {
+	Type    string        `yaml:"type"`
+	Columns []FixedColumn `yaml:"columns"`
+}
+
+// FixedColumn defines the position of a column by its 1-based start and
+// either its length or its inclusive end
+type FixedColumn struct {
+	Name   string `yaml:"name"`
+	Start  int    `yaml:"start"`
+	Length int    `yaml:"length"`
+	End    int    `yaml:"end"`
+	Trim   string `yaml:"trim"`
+	Pad    string `yaml:"pad"`
+}
+
+// Jobs defines how uploads are processed in the background
+type Jobs struct {
+	Workers        int           `yaml:"workers"`
+	Store          string        `yaml:"store"`
+	Retention      time.Duration `yaml:"retention"`
+	UploaderHeader string        `yaml:"uploader_header"`
+	RejectedDir    string        `yaml:"rejected_dir"`
 }
 
 // Config represents the entire YAML configuration
@@ -82,4 +238,6 @@ type Config struct {
 	Rules    []Rule             `yaml:"Rules"`
 	Plugins  []string           `yaml:"Plugins"`
 	Handlers []handlers.Handler `yaml:"Handlers"`
+	Jobs     Jobs               `yaml:"Jobs"`
+	Lookups  []Lookup           `yaml:"Lookups"`
 }
@@ -0,0 +1,299 @@
+package networking
+
+import (
+	"bufio"
+	"datenkarte/internal/models"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// BatchResult reports the outcome of one request sent by a Delivery
+type BatchResult struct {
+	Batch    int    `json:"batch"`
+	Rows     int    `json:"rows"`
+	Bytes    int    `json:"bytes"`
+	Status   int    `json:"status,omitempty"`
+	Attempts int    `json:"attempts,omitempty"`
+	Response string `json:"response,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// RowStats counts the rows sent in per_row mode
+type RowStats struct {
+	Sent     int `json:"sent"`
+	Failed   int `json:"failed"`
+	Attempts int `json:"attempts"`
+}
+
+// maxReportedRows limits the failed rows kept in per_row mode, further
+// failures are only counted.
+const maxReportedRows = 1000
+
+// maxResponseLength limits how much of a response body is kept per batch
+const maxResponseLength = 1024
+
+func (r *BatchResult) record(resp response) {
+	r.Status = resp.status
+	r.Attempts = resp.attempts
+	r.Response = resp.body
+	if len(r.Response) > maxResponseLength {
+		r.Response = r.Response[:maxResponseLength] + "..."
+	}
+}
+
+// Delivery modes of a rule's HTTP configuration
+const (
+	ModeBatch  = "batch"
+	ModePerRow = "per_row"
+)
+
+// Delivery sends the payloads of an upload as JSON arrays to the rule's HTTP
+// target, either all in one request or split by batch_size and max_bytes.
+// The current batch is encoded into a temporary spool file as payloads
+// arrive, so memory use does not grow with the size of the upload. In
+// per_row mode every payload is sent on its own as soon as it arrives and
+// only failed rows are kept as results.
+type Delivery struct {
+	rule    models.Rule
+	row     *rowTemplate
+	stats   *RowStats
+	spool   *os.File
+	writer  *bufio.Writer
+	prefix  string
+	suffix  string
+	rows    int
+	size    int
+	results []BatchResult
+}
+
+func NewDelivery(rule models.Rule) (*Delivery, error) {
+	if rule.Http == nil {
+		return nil, fmt.Errorf("no HTTP configuration provided in rule")
+	}
+
+	switch rule.Http.Mode {
+	case "", ModeBatch:
+	case ModePerRow:
+		row, err := newRowTemplate(rule.Http)
+		if err != nil {
+			return nil, err
+		}
+		return &Delivery{rule: rule, row: row, stats: &RowStats{}}, nil
+	default:
+		return nil, fmt.Errorf("unknown http mode: %s", rule.Http.Mode)
+	}
+
+	spool, err := os.CreateTemp("", "datenkarte-*.json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spool file: %v", err)
+	}
+
+	prefix, suffix := wrapPayloadKey(rule.Http.PayloadKey)
+	return &Delivery{
+		rule:   rule,
+		spool:  spool,
+		writer: bufio.NewWriter(spool),
+		prefix: prefix + "[",
+		suffix: "]" + suffix,
+	}, nil
+}
+
+func (d *Delivery) Write(payload map[string]interface{}) error {
+	if d.row != nil {
+		d.sendRow(payload)
+		return nil
+	}
+
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to serialize payload: %v", err)
+	}
+
+	if d.rows > 0 && d.full(len(payloadBytes)) {
+		d.flush()
+	}
+
+	separator := ","
+	if d.rows == 0 {
+		separator = d.prefix
+	}
+	if _, err := d.writer.WriteString(separator); err != nil {
+		return fmt.Errorf("failed to write spool file: %v", err)
+	}
+	if _, err := d.writer.Write(payloadBytes); err != nil {
+		return fmt.Errorf("failed to write spool file: %v", err)
+	}
+	d.rows++
+	d.size += len(separator) + len(payloadBytes)
+	return nil
+}
+
+// full reports whether the current batch has no room for another payload of
+// the given size.
+func (d *Delivery) full(payloadSize int) bool {
+	if d.rule.Http.BatchSize > 0 && d.rows >= d.rule.Http.BatchSize {
+		return true
+	}
+	if d.rule.Http.MaxBytes > 0 && d.size+1+payloadSize+len(d.suffix) > d.rule.Http.MaxBytes {
+		return true
+	}
+	return false
+}
+
+// flush sends the current batch and resets the spool file. Failures are
+// recorded in the batch results so the remaining batches are still sent.
+func (d *Delivery) flush() {
+	result := BatchResult{Batch: len(d.results) + 1, Rows: d.rows}
+	if err := d.sendBatch(&result); err != nil {
+		result.Error = err.Error()
+	}
+	d.results = append(d.results, result)
+
+	d.rows = 0
+	d.size = 0
+	d.writer.Reset(d.spool)
+	d.spool.Truncate(0)
+	d.spool.Seek(0, io.SeekStart)
+}
+
+func (d *Delivery) sendBatch(result *BatchResult) error {
+	if d.rows == 0 {
+		if _, err := d.writer.WriteString(d.prefix); err != nil {
+			return fmt.Errorf("failed to write spool file: %v", err)
+		}
+		d.size += len(d.prefix)
+	}
+	if _, err := d.writer.WriteString(d.suffix); err != nil {
+		return fmt.Errorf("failed to write spool file: %v", err)
+	}
+	if err := d.writer.Flush(); err != nil {
+		return fmt.Errorf("failed to write spool file: %v", err)
+	}
+	result.Bytes = d.size + len(d.suffix)
+
+	if d.rule.Http.MaxBytes > 0 && result.Bytes > d.rule.Http.MaxBytes {
+		return fmt.Errorf("batch of %d bytes exceeds max_bytes %d", result.Bytes, d.rule.Http.MaxBytes)
+	}
+
+	if _, err := d.spool.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind spool file: %v", err)
+	}
+
+	resp, err := send(d.rule, d.spool, int64(result.Bytes))
+	result.record(resp)
+	return err
+}
+
+// sendRow sends a single payload to the target rendered for it. Failures are
+// counted and recorded like failed batches, numbered by row, so the
+// remaining rows are still sent.
+func (d *Delivery) sendRow(payload map[string]interface{}) {
+	result := BatchResult{Batch: d.stats.Sent + d.stats.Failed + 1, Rows: 1}
+	defer func() {
+		d.stats.Attempts += result.Attempts
+		if result.Error == "" {
+			d.stats.Sent++
+			return
+		}
+		d.stats.Failed++
+		if len(d.results) < maxReportedRows {
+			d.results = append(d.results, result)
+		}
+	}()
+
+	config, err := d.row.render(*d.rule.Http, payload)
+	if err != nil {
+		result.Error = err.Error()
+		return
+	}
+	rule := d.rule
+	rule.Http = config
+
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		result.Error = fmt.Sprintf("failed to serialize payload: %v", err)
+		return
+	}
+	prefix, suffix := wrapPayloadKey(config.PayloadKey)
+	body := prefix + string(payloadBytes) + suffix
+	result.Bytes = len(body)
+
+	resp, err := send(rule, strings.NewReader(body), int64(len(body)))
+	result.record(resp)
+	if err != nil {
+		result.Error = err.Error()
+	}
+}
+
+// Close sends the last batch and removes the spool file. An upload without
+// any rows still sends one empty array.
+func (d *Delivery) Close() error {
+	defer d.Discard()
+
+	if d.stats != nil {
+		if d.stats.Failed > 0 {
+			return fmt.Errorf("%d of %d rows failed", d.stats.Failed, d.stats.Sent+d.stats.Failed)
+		}
+		return nil
+	}
+
+	if d.rows > 0 || len(d.results) == 0 {
+		d.flush()
+	}
+
+	failed := 0
+	for _, result := range d.results {
+		if result.Error != "" {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d batches failed", failed, len(d.results))
+	}
+	return nil
+}
+
+// Discard removes the spool file without sending the pending batch.
+func (d *Delivery) Discard() {
+	if d.spool == nil {
+		return
+	}
+	d.spool.Close()
+	os.Remove(d.spool.Name())
+	d.spool = nil
+}
+
+// Results returns the outcome of every batch sent so far, in per_row mode of
+// the first failed rows.
+func (d *Delivery) Results() []BatchResult {
+	if d.results == nil {
+		return []BatchResult{}
+	}
+	return d.results
+}
+
+// RowStats returns the counts of sent and failed rows in per_row mode and
+// nil otherwise.
+func (d *Delivery) RowStats() *RowStats {
+	return d.stats
+}
+
+// wrapPayloadKey returns the JSON that opens and closes the objects named by
+// the dot separated payload key, e.g. `{"a":{"b":` and `}}` for "a.b".
+func wrapPayloadKey(payloadKey string) (string, string) {
+	if payloadKey == "" {
+		return "", ""
+	}
+	var prefix strings.Builder
+	keys := strings.Split(payloadKey, ".")
+	for _, key := range keys {
+		keyBytes, _ := json.Marshal(key)
+		prefix.WriteString("{")
+		prefix.Write(keyBytes)
+		prefix.WriteString(":")
+	}
+	return prefix.String(), strings.Repeat("}", len(keys))
+}
@@ -0,0 +1,245 @@
+package networking
+
+import (
+	"datenkarte/internal/models"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// target is a test server recording the bodies it receives and answering
+// with the given statuses in turn, 200 once they are used up.
+type target struct {
+	*httptest.Server
+	mu       sync.Mutex
+	bodies   []string
+	statuses []int
+}
+
+func newTarget(t *testing.T, statuses ...int) *target {
+	tg := &target{statuses: statuses}
+	tg.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		tg.mu.Lock()
+		tg.bodies = append(tg.bodies, string(body))
+		status := http.StatusOK
+		if len(tg.statuses) > 0 {
+			status, tg.statuses = tg.statuses[0], tg.statuses[1:]
+		}
+		tg.mu.Unlock()
+		w.WriteHeader(status)
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	t.Cleanup(tg.Close)
+	return tg
+}
+
+func deliver(t *testing.T, config models.HttpType, rows int) (*Delivery, error) {
+	t.Helper()
+	config.Method = http.MethodPost
+	delivery, err := NewDelivery(models.Rule{Http: &config})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= rows; i++ {
+		if err := delivery.Write(map[string]interface{}{"n": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return delivery, delivery.Close()
+}
+
+func TestDeliveryBatches(t *testing.T) {
+	tests := []struct {
+		name   string
+		config models.HttpType
+		rows   int
+		want   []string
+	}{
+		{
+			name: "one request without limits",
+			rows: 3,
+			want: []string{`[{"n":1},{"n":2},{"n":3}]`},
+		},
+		{
+			name: "no rows",
+			rows: 0,
+			want: []string{`[]`},
+		},
+		{
+			name:   "batch size",
+			config: models.HttpType{BatchSize: 2},
+			rows:   5,
+			want:   []string{`[{"n":1},{"n":2}]`, `[{"n":3},{"n":4}]`, `[{"n":5}]`},
+		},
+		{
+			name:   "batch size dividing the rows",
+			config: models.HttpType{BatchSize: 2},
+			rows:   4,
+			want:   []string{`[{"n":1},{"n":2}]`, `[{"n":3},{"n":4}]`},
+		},
+		{
+			name:   "max bytes",
+			config: models.HttpType{MaxBytes: 25},
+			rows:   5,
+			want:   []string{`[{"n":1},{"n":2},{"n":3}]`, `[{"n":4},{"n":5}]`},
+		},
+		{
+			name:   "batch size and max bytes",
+			config: models.HttpType{BatchSize: 2, MaxBytes: 16},
+			rows:   3,
+			want:   []string{`[{"n":1}]`, `[{"n":2}]`, `[{"n":3}]`},
+		},
+		{
+			name:   "payload key counts towards max bytes",
+			config: models.HttpType{PayloadKey: "data.items", MaxBytes: 40},
+			rows:   3,
+			want:   []string{`{"data":{"items":[{"n":1},{"n":2}]}}`, `{"data":{"items":[{"n":3}]}}`},
+		},
+	}
+	for _, test := range tests {
+		tg := newTarget(t)
+		test.config.Url = tg.URL
+		delivery, err := deliver(t, test.config, test.rows)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if strings.Join(tg.bodies, "\n") != strings.Join(test.want, "\n") {
+			t.Errorf("%s: sent %q, want %q", test.name, tg.bodies, test.want)
+		}
+
+		results := delivery.Results()
+		if len(results) != len(test.want) {
+			t.Errorf("%s: %d results, want %d", test.name, len(results), len(test.want))
+			continue
+		}
+		for i, result := range results {
+			if result.Batch != i+1 || result.Bytes != len(test.want[i]) || result.Status != http.StatusOK {
+				t.Errorf("%s: result %d = %+v", test.name, i, result)
+			}
+			if test.config.MaxBytes > 0 && result.Bytes > test.config.MaxBytes {
+				t.Errorf("%s: batch %d has %d bytes, more than max_bytes", test.name, result.Batch, result.Bytes)
+			}
+		}
+	}
+}
+
+func TestDeliveryPayloadLargerThanMaxBytes(t *testing.T) {
+	tg := newTarget(t)
+	delivery, err := deliver(t, models.HttpType{Url: tg.URL, MaxBytes: 5}, 2)
+	if err == nil || err.Error() != "2 of 2 batches failed" {
+		t.Errorf("error = %v, want both batches failed", err)
+	}
+	if len(tg.bodies) != 0 {
+		t.Errorf("sent %q, want nothing", tg.bodies)
+	}
+	for _, result := range delivery.Results() {
+		if result.Error != "batch of 9 bytes exceeds max_bytes 5" {
+			t.Errorf("result %d error = %q", result.Batch, result.Error)
+		}
+	}
+}
+
+func TestDeliveryFailedBatchDoesNotStopOthers(t *testing.T) {
+	tg := newTarget(t, http.StatusBadRequest)
+	delivery, err := deliver(t, models.HttpType{Url: tg.URL, BatchSize: 1}, 3)
+	if err == nil || err.Error() != "1 of 3 batches failed" {
+		t.Errorf("error = %v, want 1 of 3 batches failed", err)
+	}
+	if len(tg.bodies) != 3 {
+		t.Errorf("sent %d batches, want 3", len(tg.bodies))
+	}
+
+	results := delivery.Results()
+	if results[0].Status != http.StatusBadRequest || results[0].Error == "" {
+		t.Errorf("first batch = %+v, want failed with 400", results[0])
+	}
+	for _, result := range results[1:] {
+		if result.Status != http.StatusOK || result.Error != "" {
+			t.Errorf("batch %d = %+v, want sent", result.Batch, result)
+		}
+	}
+}
+
+func TestDeliveryDiscard(t *testing.T) {
+	tg := newTarget(t)
+	delivery, err := NewDelivery(models.Rule{Http: &models.HttpType{Url: tg.URL, Method: http.MethodPost}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	delivery.Write(map[string]interface{}{"n": 1})
+	delivery.Discard()
+	if len(tg.bodies) != 0 {
+		t.Errorf("sent %q after discard", tg.bodies)
+	}
+}
+
+func TestDeliveryPerRow(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		requests = append(requests, r.Method+" "+r.URL.Path+" "+r.Header.Get("X-Id")+" "+string(body))
+		mu.Unlock()
+		if r.URL.Path == "/users/2" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	config := models.HttpType{
+		Url:     server.URL + "/users/{{ .n }}",
+		Method:  "{{ if eq .n 3 }}DELETE{{ else }}PUT{{ end }}",
+		Mode:    ModePerRow,
+		Headers: []models.HttpHeader{{Name: "X-Id", Value: "row-{{ .n }}"}},
+	}
+	delivery, err := NewDelivery(models.Rule{Http: &config})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 3; i++ {
+		delivery.Write(map[string]interface{}{"n": i})
+	}
+	if err := delivery.Close(); err == nil || err.Error() != "1 of 3 rows failed" {
+		t.Errorf("error = %v, want 1 of 3 rows failed", err)
+	}
+
+	want := []string{
+		`PUT /users/1 row-1 {"n":1}`,
+		`PUT /users/2 row-2 {"n":2}`,
+		`DELETE /users/3 row-3 {"n":3}`,
+	}
+	if strings.Join(requests, "\n") != strings.Join(want, "\n") {
+		t.Errorf("requests %q, want %q", requests, want)
+	}
+	if stats := *delivery.RowStats(); stats != (RowStats{Sent: 2, Failed: 1, Attempts: 3}) {
+		t.Errorf("stats = %+v", stats)
+	}
+	results := delivery.Results()
+	if len(results) != 1 || results[0].Batch != 2 || results[0].Status != http.StatusNotFound {
+		t.Errorf("results = %+v, want only row 2", results)
+	}
+}
+
+func TestDeliveryPerRowLimitsFailedRows(t *testing.T) {
+	tg := newTarget(t)
+	tg.statuses = make([]int, maxReportedRows+5)
+	for i := range tg.statuses {
+		tg.statuses[i] = http.StatusBadRequest
+	}
+	delivery, err := deliver(t, models.HttpType{Url: tg.URL, Mode: ModePerRow}, maxReportedRows+10)
+	if err == nil {
+		t.Error("expected failed rows")
+	}
+	if stats := *delivery.RowStats(); stats != (RowStats{Sent: 5, Failed: maxReportedRows + 5, Attempts: maxReportedRows + 10}) {
+		t.Errorf("stats = %+v", stats)
+	}
+	if len(delivery.Results()) != maxReportedRows {
+		t.Errorf("%d results kept, want %d", len(delivery.Results()), maxReportedRows)
+	}
+}
@@ -6,9 +6,57 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log"
+	"net"
 	"net/http"
+	"sync"
+	"time"
 )
 
+// Timeouts of rules that do not set their own
+const (
+	defaultTimeout        = 60 * time.Second
+	defaultConnectTimeout = 10 * time.Second
+)
+
+type clientKey struct {
+	timeout        time.Duration
+	connectTimeout time.Duration
+}
+
+var (
+	clients   = make(map[clientKey]*http.Client)
+	clientsMu sync.Mutex
+)
+
+// clientFor returns a shared client for the timeouts of the HTTP
+// configuration, so connections are reused across requests.
+func clientFor(config *models.HttpType) *http.Client {
+	key := clientKey{timeout: config.Timeout, connectTimeout: config.ConnectTimeout}
+	if key.timeout <= 0 {
+		key.timeout = defaultTimeout
+	}
+	if key.connectTimeout <= 0 {
+		key.connectTimeout = defaultConnectTimeout
+	}
+
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+
+	if client, exists := clients[key]; exists {
+		return client
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{
+		Timeout:   key.connectTimeout,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	client := &http.Client{Transport: transport, Timeout: key.timeout}
+	clients[key] = client
+	return client
+}
+
 func SendPayload(rule models.Rule, payload interface{}) error {
 	if rule.Http == nil {
 		return fmt.Errorf("no HTTP configuration provided in rule")
@@ -20,48 +68,95 @@ func SendPayload(rule models.Rule, payload interface{}) error {
 		return fmt.Errorf("failed to serialize payload: %v", err)
 	}
 
-	// Create the HTTP request
-	req, err := http.NewRequest(rule.Http.Method, rule.Http.Url, bytes.NewBuffer(payloadBytes))
+	_, err = send(rule, bytes.NewReader(payloadBytes), int64(len(payloadBytes)))
+	return err
+}
+
+// response is the last answer of the target to a request
+type response struct {
+	status   int
+	attempts int
+	body     string
+}
+
+// send posts body to the rule's HTTP target, retrying according to the
+// rule's retry policy, and returns the last response.
+func send(rule models.Rule, body io.ReadSeeker, size int64) (response, error) {
+	policy := newRetryPolicy(rule.Http.Retry)
+	client := clientFor(rule.Http)
+
+	for attempt := 1; ; attempt++ {
+		if _, err := body.Seek(0, io.SeekStart); err != nil {
+			return response{attempts: attempt}, fmt.Errorf("failed to rewind request body: %v", err)
+		}
+
+		req, err := newRequest(rule.Http, body, size)
+		if err != nil {
+			return response{attempts: attempt}, err
+		}
+
+		resp, retryAfter, err := do(client, req)
+		resp.attempts = attempt
+		if err == nil || attempt >= policy.maxAttempts || !policy.retryable(resp.status) {
+			return resp, err
+		}
+
+		wait := policy.backoff(attempt, retryAfter)
+		log.Printf("Retrying %s in %s (attempt %d of %d): %v", rule.Http.Url, wait, attempt+1, policy.maxAttempts, err)
+		time.Sleep(wait)
+	}
+}
+
+func newRequest(config *models.HttpType, body io.Reader, size int64) (*http.Request, error) {
+	// Create the HTTP request, the client must not close the reused body
+	req, err := http.NewRequest(config.Method, config.Url, io.NopCloser(body))
 	if err != nil {
-		return fmt.Errorf("failed to create HTTP request: %v", err)
+		return nil, fmt.Errorf("failed to create HTTP request: %v", err)
 	}
+	req.ContentLength = size
 
 	// Set headers
-	for _, header := range rule.Http.Headers {
+	for _, header := range config.Headers {
 		req.Header.Set(header.Name, header.Value)
 	}
 
 	// Set authentication
-	if rule.Http.Auth != nil {
-		switch rule.Http.Auth.Type {
+	if config.Auth != nil {
+		switch config.Auth.Type {
 		case "bearer":
-			req.Header.Set("Authorization", "Bearer "+rule.Http.Auth.Value)
+			req.Header.Set("Authorization", "Bearer "+config.Auth.Value)
 		case "basic":
-			req.SetBasicAuth(rule.Http.Auth.Value, "") // Adjust if username/password are needed
+			req.SetBasicAuth(config.Auth.Value, "") // Adjust if username/password are needed
 		default:
-			return fmt.Errorf("unsupported auth type: %s", rule.Http.Auth.Type)
+			return nil, fmt.Errorf("unsupported auth type: %s", config.Auth.Type)
 		}
 	}
 
-	// Send the request
-	client := &http.Client{}
+	return req, nil
+}
+
+// do sends a single request and returns the response and the wait requested
+// by a Retry-After header.
+func do(client *http.Client, req *http.Request) (response, time.Duration, error) {
 	resp, err := client.Do(req)
 	if err != nil {
-		return fmt.Errorf("HTTP request failed: %v", err)
+		return response{}, 0, fmt.Errorf("HTTP request failed: %v", err)
 	}
 	defer resp.Body.Close()
 
 	// Read the response
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("failed to read response body: %v", err)
+		return response{}, 0, fmt.Errorf("failed to read response body: %v", err)
 	}
 
 	// Log the response
-	fmt.Printf("Response from %s: %s\n", rule.Http.Url, string(body))
+	fmt.Printf("Response from %s: %s\n", req.URL, string(body))
+	result := response{status: resp.StatusCode, body: string(body)}
 	if resp.StatusCode >= 400 {
-		return fmt.Errorf("HTTP request failed with status %d: %s", resp.StatusCode, string(body))
+		retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"))
+		return result, retryAfter, fmt.Errorf("HTTP request failed with status %d: %s", resp.StatusCode, string(body))
 	}
 
-	return nil
+	return result, 0, nil
 }
@@ -0,0 +1,114 @@
+package networking
+
+import (
+	"datenkarte/internal/models"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultMaxAttempts = 3
+	defaultBaseBackoff = 500 * time.Millisecond
+	defaultMaxBackoff  = 30 * time.Second
+)
+
+// defaultRetryStatuses are the response codes retried when a rule does not
+// list its own.
+var defaultRetryStatuses = []int{
+	http.StatusRequestTimeout,
+	http.StatusTooEarly,
+	http.StatusTooManyRequests,
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// retryPolicy is a rule's retry configuration with defaults applied
+type retryPolicy struct {
+	maxAttempts int
+	baseBackoff time.Duration
+	maxBackoff  time.Duration
+	jitter      float64
+	statuses    []int
+}
+
+func newRetryPolicy(retry *models.Retry) retryPolicy {
+	if retry == nil {
+		return retryPolicy{maxAttempts: 1}
+	}
+
+	p := retryPolicy{
+		maxAttempts: retry.MaxAttempts,
+		baseBackoff: retry.BaseBackoff,
+		maxBackoff:  retry.MaxBackoff,
+		jitter:      retry.Jitter,
+		statuses:    retry.Statuses,
+	}
+	if p.maxAttempts <= 0 {
+		p.maxAttempts = defaultMaxAttempts
+	}
+	if p.baseBackoff <= 0 {
+		p.baseBackoff = defaultBaseBackoff
+	}
+	if p.maxBackoff <= 0 {
+		p.maxBackoff = defaultMaxBackoff
+	}
+	if len(p.statuses) == 0 {
+		p.statuses = defaultRetryStatuses
+	}
+	return p
+}
+
+// retryable reports whether a response with the given status is retried. A
+// status of 0 means the request failed before a response was received.
+func (p retryPolicy) retryable(status int) bool {
+	if status == 0 {
+		return true
+	}
+	for _, s := range p.statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the wait before the next attempt: the base backoff doubled
+// for every failed attempt plus jitter, or the server's Retry-After if that
+// is longer, capped at the maximum backoff.
+func (p retryPolicy) backoff(attempt int, retryAfter time.Duration) time.Duration {
+	wait := p.baseBackoff
+	for i := 1; i < attempt && wait < p.maxBackoff; i++ {
+		wait *= 2
+	}
+	if p.jitter > 0 {
+		wait += time.Duration(rand.Float64() * p.jitter * float64(wait))
+	}
+	if retryAfter > wait {
+		wait = retryAfter
+	}
+	if wait > p.maxBackoff {
+		wait = p.maxBackoff
+	}
+	return wait
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an HTTP
+// date.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
@@ -0,0 +1,167 @@
+package networking
+
+import (
+	"datenkarte/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDeliveryRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		retry    *models.Retry
+		statuses []int
+		attempts int
+		status   int
+	}{
+		{
+			name:     "no retry configured",
+			statuses: []int{http.StatusServiceUnavailable},
+			attempts: 1,
+			status:   http.StatusServiceUnavailable,
+		},
+		{
+			name:     "retried until sent",
+			retry:    &models.Retry{BaseBackoff: time.Millisecond},
+			statuses: []int{http.StatusServiceUnavailable, http.StatusBadGateway},
+			attempts: 3,
+			status:   http.StatusOK,
+		},
+		{
+			name:     "gives up after max attempts",
+			retry:    &models.Retry{MaxAttempts: 2, BaseBackoff: time.Millisecond},
+			statuses: []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable},
+			attempts: 2,
+			status:   http.StatusServiceUnavailable,
+		},
+		{
+			name:     "status not retryable",
+			retry:    &models.Retry{BaseBackoff: time.Millisecond},
+			statuses: []int{http.StatusBadRequest},
+			attempts: 1,
+			status:   http.StatusBadRequest,
+		},
+		{
+			name:     "own statuses",
+			retry:    &models.Retry{BaseBackoff: time.Millisecond, Statuses: []int{http.StatusConflict}},
+			statuses: []int{http.StatusConflict, http.StatusServiceUnavailable},
+			attempts: 2,
+			status:   http.StatusServiceUnavailable,
+		},
+	}
+	for _, test := range tests {
+		tg := newTarget(t, test.statuses...)
+		delivery, _ := deliver(t, models.HttpType{Url: tg.URL, Retry: test.retry}, 1)
+		if len(tg.bodies) != test.attempts {
+			t.Errorf("%s: %d requests, want %d", test.name, len(tg.bodies), test.attempts)
+		}
+		result := delivery.Results()[0]
+		if result.Attempts != test.attempts || result.Status != test.status {
+			t.Errorf("%s: result = %+v, want %d attempts with %d", test.name, result, test.attempts, test.status)
+		}
+	}
+}
+
+func TestDeliveryRetryAfter(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer server.Close()
+
+	retry := &models.Retry{BaseBackoff: time.Millisecond, MaxBackoff: 200 * time.Millisecond}
+	start := time.Now()
+	if _, err := deliver(t, models.HttpType{Url: server.URL, Retry: retry}, 1); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < retry.MaxBackoff || elapsed >= time.Second {
+		t.Errorf("took %s, want Retry-After capped at max_backoff", elapsed)
+	}
+	if requests != 2 {
+		t.Errorf("%d requests, want 2", requests)
+	}
+}
+
+func TestDeliveryRetriesTimeout(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			time.Sleep(200 * time.Millisecond)
+		}
+	}))
+	defer server.Close()
+
+	config := models.HttpType{
+		Url:     server.URL,
+		Timeout: 50 * time.Millisecond,
+		Retry:   &models.Retry{BaseBackoff: time.Millisecond},
+	}
+	delivery, err := deliver(t, config, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result := delivery.Results()[0]; result.Attempts != 2 || result.Status != http.StatusOK {
+		t.Errorf("result = %+v, want sent on the second attempt", result)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	p := newRetryPolicy(&models.Retry{BaseBackoff: time.Second, MaxBackoff: 5 * time.Second})
+	tests := []struct {
+		attempt    int
+		retryAfter time.Duration
+		want       time.Duration
+	}{
+		{1, 0, time.Second},
+		{2, 0, 2 * time.Second},
+		{3, 0, 4 * time.Second},
+		{4, 0, 5 * time.Second},
+		{10, 0, 5 * time.Second},
+		{1, 3 * time.Second, 3 * time.Second},
+		{3, 3 * time.Second, 4 * time.Second},
+		{1, time.Minute, 5 * time.Second},
+	}
+	for _, test := range tests {
+		if got := p.backoff(test.attempt, test.retryAfter); got != test.want {
+			t.Errorf("backoff(%d, %s) = %s, want %s", test.attempt, test.retryAfter, got, test.want)
+		}
+	}
+
+	p.jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if got := p.backoff(1, 0); got < time.Second || got > 1500*time.Millisecond {
+			t.Fatalf("backoff with jitter = %s", got)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"3", 3 * time.Second},
+		{"0", 0},
+		{"-1", 0},
+		{"soon", 0},
+		{time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 0},
+	}
+	for _, test := range tests {
+		if got := parseRetryAfter(test.value); got != test.want {
+			t.Errorf("parseRetryAfter(%q) = %s, want %s", test.value, got, test.want)
+		}
+	}
+
+	date := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	if got := parseRetryAfter(date); got < 59*time.Minute || got > time.Hour {
+		t.Errorf("parseRetryAfter(%q) = %s, want about an hour", date, got)
+	}
+}
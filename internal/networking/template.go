@@ -0,0 +1,77 @@
+package networking
+
+import (
+	"bytes"
+	"datenkarte/internal/models"
+	"fmt"
+	"net/url"
+	"text/template"
+)
+
+var templateFuncs = template.FuncMap{
+	"path": url.PathEscape,
+}
+
+// rowTemplate renders the url, method and header values of a rule's HTTP
+// configuration against a mapped row, e.g. "http://api/users/{{ .id }}".
+type rowTemplate struct {
+	url     *template.Template
+	method  *template.Template
+	headers []*template.Template
+}
+
+func newRowTemplate(config *models.HttpType) (*rowTemplate, error) {
+	t := &rowTemplate{}
+	var err error
+	if t.url, err = parseTemplate("url", config.Url); err != nil {
+		return nil, err
+	}
+	if t.method, err = parseTemplate("method", config.Method); err != nil {
+		return nil, err
+	}
+	for _, header := range config.Headers {
+		h, err := parseTemplate(header.Name, header.Value)
+		if err != nil {
+			return nil, err
+		}
+		t.headers = append(t.headers, h)
+	}
+	return t, nil
+}
+
+func parseTemplate(name string, text string) (*template.Template, error) {
+	t, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template for %s: %v", name, err)
+	}
+	return t, nil
+}
+
+// render returns a copy of config with all templates executed against row.
+func (t *rowTemplate) render(config models.HttpType, row map[string]interface{}) (*models.HttpType, error) {
+	var err error
+	if config.Url, err = executeTemplate(t.url, row); err != nil {
+		return nil, err
+	}
+	if config.Method, err = executeTemplate(t.method, row); err != nil {
+		return nil, err
+	}
+	headers := make([]models.HttpHeader, len(config.Headers))
+	for i, header := range config.Headers {
+		value, err := executeTemplate(t.headers[i], row)
+		if err != nil {
+			return nil, err
+		}
+		headers[i] = models.HttpHeader{Name: header.Name, Value: value}
+	}
+	config.Headers = headers
+	return &config, nil
+}
+
+func executeTemplate(t *template.Template, row map[string]interface{}) (string, error) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, row); err != nil {
+		return "", fmt.Errorf("failed to render %s: %v", t.Name(), err)
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,192 @@
+package pipeline
+
+import (
+	"bufio"
+	"datenkarte/internal/models"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	DelimiterAuto = "auto"
+
+	// sniffLines is how many lines delimiter sniffing looks at
+	sniffLines = 10
+)
+
+// delimiterCandidates are the delimiters auto detection chooses from, in
+// the order preferred on a tie
+var delimiterCandidates = []rune{',', ';', '\t', '|'}
+
+// resolveDelimiter replaces the delimiter auto with the delimiter sniffed
+// from the start of src and rewinds src.
+func resolveDelimiter(rule models.Rule, src io.ReadSeeker) (models.Rule, error) {
+	if rule.Delimiter != DelimiterAuto {
+		return rule, nil
+	}
+
+	text, err := decodeText(rule, bufio.NewReaderSize(src, sniffLength))
+	if err != nil {
+		return rule, err
+	}
+	sample, _ := text.Peek(sniffLength)
+	rule.Delimiter = string(sniffDelimiter(sample, quoteOf(rule), rule.Comment))
+
+	_, err = src.Seek(0, io.SeekStart)
+	return rule, err
+}
+
+// sniffDelimiter picks the candidate found the same number of times in every
+// line outside of quotes, preferring the one found most often. Without such
+// a candidate the one found most often in the first line wins, and ";" if
+// there is none.
+func sniffDelimiter(sample []byte, quote byte, comment string) rune {
+	var lines []string
+	for _, line := range strings.Split(string(sample), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" || (comment != "" && strings.HasPrefix(line, comment)) {
+			continue
+		}
+		lines = append(lines, line)
+		if len(lines) == sniffLines {
+			break
+		}
+	}
+	// The last line may be cut off at the end of the sample
+	if len(sample) == sniffLength && len(lines) > 1 && len(lines) < sniffLines {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return ';'
+	}
+
+	best, bestCount, consistent := ';', 0, false
+	for _, candidate := range delimiterCandidates {
+		first := countOutsideQuotes(lines[0], candidate, quote)
+		if first == 0 {
+			continue
+		}
+		same := true
+		for _, line := range lines[1:] {
+			if countOutsideQuotes(line, candidate, quote) != first {
+				same = false
+				break
+			}
+		}
+		if (same && !consistent) || (same == consistent && first > bestCount) {
+			best, bestCount, consistent = candidate, first, same
+		}
+	}
+	return best
+}
+
+func countOutsideQuotes(line string, delimiter rune, quote byte) int {
+	count := 0
+	quoted := false
+	for _, r := range line {
+		switch {
+		case r == rune(quote):
+			quoted = !quoted
+		case r == delimiter && !quoted:
+			count++
+		}
+	}
+	return count
+}
+
+// quoteOf returns the quote character of the rule, '"' by default.
+func quoteOf(rule models.Rule) byte {
+	if rule.Quote == "" {
+		return '"'
+	}
+	return rule.Quote[0]
+}
+
+// csvReader reads CSV records with the rule's dialect. encoding/csv only
+// knows '"' as quote character, so another quote character is swapped with
+// '"' in the input and swapped back in every field. With variable_fields
+// short records are padded to the width of the header.
+type csvReader struct {
+	reader *csv.Reader
+	quote  byte
+	width  int
+}
+
+func newCSVReader(rule models.Rule, src io.Reader) (*csvReader, error) {
+	quote := quoteOf(rule)
+	if len(rule.Quote) > 1 || quote >= 0x80 || quote == '\n' || quote == '\r' || rune(quote) == delimiterOf(rule) {
+		return nil, fmt.Errorf("invalid quote: %q", rule.Quote)
+	}
+	if quote != '"' {
+		src = &swapReader{src: src, a: quote, b: '"'}
+	}
+
+	reader := csv.NewReader(src)
+	reader.Comma = delimiterOf(rule)
+	reader.LazyQuotes = rule.LazyQuotes
+	reader.TrimLeadingSpace = rule.TrimLeadingSpace
+	if rule.Comment != "" {
+		reader.Comment = []rune(rule.Comment)[0]
+	}
+	if rule.VariableFields {
+		reader.FieldsPerRecord = -1
+	}
+	return &csvReader{reader: reader, quote: quote}, nil
+}
+
+func (r *csvReader) Read() ([]string, error) {
+	record, err := r.reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	if r.quote != '"' {
+		for i, field := range record {
+			record[i] = swap(field, r.quote, '"')
+		}
+	}
+
+	if r.width == 0 {
+		r.width = len(record)
+	}
+	for len(record) < r.width {
+		record = append(record, "")
+	}
+	return record, nil
+}
+
+// swapReader exchanges two bytes in everything read from src.
+type swapReader struct {
+	src  io.Reader
+	a, b byte
+}
+
+func (s *swapReader) Read(p []byte) (int, error) {
+	n, err := s.src.Read(p)
+	for i, c := range p[:n] {
+		switch c {
+		case s.a:
+			p[i] = s.b
+		case s.b:
+			p[i] = s.a
+		}
+	}
+	return n, err
+}
+
+func swap(s string, a, b byte) string {
+	if !strings.ContainsAny(s, string([]byte{a, b})) {
+		return s
+	}
+	swapped := []byte(s)
+	for i, c := range swapped {
+		switch c {
+		case a:
+			swapped[i] = b
+		case b:
+			swapped[i] = a
+		}
+	}
+	return string(swapped)
+}
@@ -0,0 +1,150 @@
+package pipeline
+
+import (
+	"bytes"
+	"datenkarte/internal/models"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestSniffDelimiter(t *testing.T) {
+	// Long lines with one ',' and many ';', the sample ends in the middle of
+	// the third line which has fewer ';'
+	line := "x," + strings.Repeat("x;", 15000) + "x\n"
+	cut := strings.Repeat(line, 3)[:sniffLength]
+
+	tests := []struct {
+		name    string
+		sample  string
+		quote   byte
+		comment string
+		want    rune
+	}{
+		{"comma", "a,b,c\n1,2,3\n", '"', "", ','},
+		{"semicolon", "a;b;c\n1;2;3\n", '"', "", ';'},
+		{"tab", "a\tb\n1\t2\n", '"', "", '\t'},
+		{"pipe", "a|b\n1|2\n", '"', "", '|'},
+		{"crlf", "a,b\r\n1,2\r\n", '"', "", ','},
+		{"single line", "a;b;c", '"', "", ';'},
+		{"empty", "", '"', "", ';'},
+		{"no candidate", "name\nvalue\n", '"', "", ';'},
+		{"consistent wins over frequent", "a;b,c,d\n1;2 3\n4;5,6\n", '"', "", ';'},
+		{"more frequent wins among consistent", "a,b;c,d\n1,2;3,4\n", '"', "", ','},
+		{"most frequent in the first line without a consistent one", "a,b,c;d\n1;2;3\n", '"', "", ','},
+		{"tie prefers comma", "a,b;c\n1,2;3\n", '"', "", ','},
+		{"quoted delimiters are not counted", "name;note\n\"Doe, J.\";\"a, b, c\"\n", '"', "", ';'},
+		{"other quote character", "name;note\n'Doe, J.';'a, b'\n", '\'', "", ';'},
+		{"empty lines are skipped", "a,b\n\n1,2\n\n", '"', "", ','},
+		{"comment lines are skipped", "# x;y;z\na,b\n1,2\n", '"', "#", ','},
+		{"only the first lines count", strings.Repeat("a,b\n", sniffLines) + "a;b;c;d;e\n", '"', "", ','},
+		{"cut off last line is ignored", cut, '"', "", ';'},
+	}
+	for _, test := range tests {
+		got := sniffDelimiter([]byte(test.sample), test.quote, test.comment)
+		if got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestResolveDelimiter(t *testing.T) {
+	src := strings.NewReader("\xEF\xBB\xBFname\tage\nAda\t36\n")
+	rule, err := resolveDelimiter(models.Rule{Delimiter: DelimiterAuto}, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule.Delimiter != "\t" {
+		t.Errorf("delimiter = %q, want tab", rule.Delimiter)
+	}
+	if offset, _ := src.Seek(0, io.SeekCurrent); offset != 0 {
+		t.Errorf("source not rewound, offset %d", offset)
+	}
+
+	rule, err = resolveDelimiter(models.Rule{Delimiter: ","}, src)
+	if err != nil || rule.Delimiter != "," {
+		t.Errorf("configured delimiter changed to %q, %v", rule.Delimiter, err)
+	}
+}
+
+func TestCSVReader(t *testing.T) {
+	tests := []struct {
+		name  string
+		rule  models.Rule
+		input string
+		want  [][]string
+	}{
+		{
+			name:  "default",
+			rule:  models.Rule{},
+			input: "a;b\n\"x;1\";\"say \"\"hi\"\"\"\n",
+			want:  [][]string{{"a", "b"}, {"x;1", `say "hi"`}},
+		},
+		{
+			name:  "single quotes",
+			rule:  models.Rule{Delimiter: ",", Quote: "'"},
+			input: "a,b\n'x,1','it''s \"ok\"'\n",
+			want:  [][]string{{"a", "b"}, {"x,1", `it's "ok"`}},
+		},
+		{
+			name:  "lazy quotes",
+			rule:  models.Rule{Delimiter: ",", LazyQuotes: true},
+			input: "a,b\n5\" disk,x\n",
+			want:  [][]string{{"a", "b"}, {`5" disk`, "x"}},
+		},
+		{
+			name:  "comment and leading space",
+			rule:  models.Rule{Delimiter: ",", Comment: "#", TrimLeadingSpace: true},
+			input: "# exported\na, b\n1,  2\n",
+			want:  [][]string{{"a", "b"}, {"1", "2"}},
+		},
+		{
+			name:  "variable fields are padded",
+			rule:  models.Rule{Delimiter: ",", VariableFields: true},
+			input: "a,b,c\n1\n1,2,3,4\n",
+			want:  [][]string{{"a", "b", "c"}, {"1", "", ""}, {"1", "2", "3", "4"}},
+		},
+	}
+	for _, test := range tests {
+		reader, err := newCSVReader(test.rule, strings.NewReader(test.input))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		var got [][]string
+		for {
+			record, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Errorf("%s: %v", test.name, err)
+				break
+			}
+			got = append(got, record)
+		}
+		if !equalRecords(got, test.want) {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCSVReaderInvalidQuote(t *testing.T) {
+	for _, quote := range []string{"''", ";", "\n", "ä"} {
+		if _, err := newCSVReader(models.Rule{Quote: quote}, bytes.NewReader(nil)); err == nil {
+			t.Errorf("quote %q accepted", quote)
+		}
+	}
+}
+
+func equalRecords(a, b [][]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if strings.Join(a[i], "\x00") != strings.Join(b[i], "\x00") || len(a[i]) != len(b[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,94 @@
+package pipeline
+
+import (
+	"bufio"
+	"bytes"
+	"datenkarte/internal/models"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+const (
+	EncodingAuto = "auto"
+
+	// sniffLength is how much of a file auto detection looks at
+	sniffLength = 64 * 1024
+)
+
+var (
+	utf8BOM    = []byte{0xEF, 0xBB, 0xBF}
+	utf16LEBOM = []byte{0xFF, 0xFE}
+	utf16BEBOM = []byte{0xFE, 0xFF}
+
+	utf16 = unicode.UTF16(unicode.LittleEndian, unicode.UseBOM)
+)
+
+// decodeText converts a text file in the rule's encoding to UTF-8. A UTF-8
+// byte order mark is always removed. With auto, files starting with a byte
+// order mark are read as UTF-8 or UTF-16, valid UTF-8 stays as it is and
+// everything else is read as Windows-1252.
+func decodeText(rule models.Rule, src *bufio.Reader) (*bufio.Reader, error) {
+	var enc encoding.Encoding
+	switch strings.ToLower(rule.Encoding) {
+	case "", "utf-8", "utf8":
+		return stripBOM(src), nil
+	case "windows-1252", "cp1252":
+		enc = charmap.Windows1252
+	case "iso-8859-1", "latin1":
+		enc = charmap.ISO8859_1
+	case "utf-16", "utf16":
+		enc = utf16
+	case EncodingAuto:
+		enc = detectEncoding(src)
+		if enc == nil {
+			return stripBOM(src), nil
+		}
+	default:
+		return nil, fmt.Errorf("unknown encoding: %s", rule.Encoding)
+	}
+	return bufio.NewReaderSize(transform.NewReader(src, enc.NewDecoder()), sniffLength), nil
+}
+
+func stripBOM(src *bufio.Reader) *bufio.Reader {
+	if start, _ := src.Peek(len(utf8BOM)); bytes.Equal(start, utf8BOM) {
+		src.Discard(len(utf8BOM))
+	}
+	return src
+}
+
+// detectEncoding returns the encoding of the start of src, or nil for UTF-8.
+func detectEncoding(src *bufio.Reader) encoding.Encoding {
+	sample, _ := src.Peek(sniffLength)
+	switch {
+	case bytes.HasPrefix(sample, utf8BOM):
+		return nil
+	case bytes.HasPrefix(sample, utf16LEBOM), bytes.HasPrefix(sample, utf16BEBOM):
+		return utf16
+	}
+
+	// The last character may be cut off at the end of the sample
+	if len(sample) == sniffLength {
+		if i := lastRuneStart(sample); !utf8.FullRune(sample[i:]) {
+			sample = sample[:i]
+		}
+	}
+	if utf8.Valid(sample) {
+		return nil
+	}
+	return charmap.Windows1252
+}
+
+func lastRuneStart(b []byte) int {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			return i
+		}
+	}
+	return len(b)
+}
@@ -0,0 +1,85 @@
+package pipeline
+
+import (
+	"bufio"
+	"bytes"
+	"datenkarte/internal/models"
+	"io"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+)
+
+func TestDetectEncoding(t *testing.T) {
+	// A multi-byte character cut off at the end of the sample
+	cut := append(bytes.Repeat([]byte("a"), sniffLength-1), "ü"...)
+
+	tests := []struct {
+		name  string
+		input []byte
+		want  encoding.Encoding
+	}{
+		{"empty", nil, nil},
+		{"ascii", []byte("Name;Ort\nA;B\n"), nil},
+		{"utf-8", []byte("Name;Ort\nMüller;Köln\n"), nil},
+		{"utf-8 with bom", append([]byte{0xEF, 0xBB, 0xBF}, "Name"...), nil},
+		{"utf-16le bom", []byte{0xFF, 0xFE, 'N', 0, 'a', 0}, utf16},
+		{"utf-16be bom", []byte{0xFE, 0xFF, 0, 'N', 0, 'a'}, utf16},
+		{"windows-1252", []byte("Name;Ort\nM\xfcller;K\xf6ln\n"), charmap.Windows1252},
+		{"utf-8 cut off at the end of the sample", cut, nil},
+	}
+	for _, test := range tests {
+		got := detectEncoding(bufio.NewReaderSize(bytes.NewReader(test.input), sniffLength))
+		if got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestDecodeText(t *testing.T) {
+	tests := []struct {
+		encoding string
+		input    []byte
+		want     string
+	}{
+		{"", []byte("Müller"), "Müller"},
+		{"", []byte("\xEF\xBB\xBFMüller"), "Müller"},
+		{"UTF-8", []byte("\xEF\xBB\xBFMüller"), "Müller"},
+		{"windows-1252", []byte("M\xfcller \x80"), "Müller €"},
+		{"cp1252", []byte("M\xfcller"), "Müller"},
+		{"iso-8859-1", []byte("M\xfcller"), "Müller"},
+		{"latin1", []byte("\xe9t\xe9"), "été"},
+		{"utf-16", []byte{0xFF, 0xFE, 'M', 0, 0xFC, 0, 'l', 0}, "Mül"},
+		{"utf-16", []byte{0xFE, 0xFF, 0, 'M', 0, 0xFC, 0, 'l'}, "Mül"},
+		{"utf-16", []byte{'M', 0, 0xFC, 0, 'l', 0}, "Mül"},
+		{"auto", []byte("\xEF\xBB\xBFMüller"), "Müller"},
+		{"auto", []byte("Müller"), "Müller"},
+		{"auto", []byte("M\xfcller \x80"), "Müller €"},
+		{"auto", []byte{0xFF, 0xFE, 'M', 0, 0xFC, 0, 'l', 0}, "Mül"},
+	}
+	for _, test := range tests {
+		src := bufio.NewReaderSize(bytes.NewReader(test.input), sniffLength)
+		text, err := decodeText(models.Rule{Encoding: test.encoding}, src)
+		if err != nil {
+			t.Errorf("%s %q: %v", test.encoding, test.input, err)
+			continue
+		}
+		got, err := io.ReadAll(text)
+		if err != nil {
+			t.Errorf("%s %q: %v", test.encoding, test.input, err)
+			continue
+		}
+		if string(got) != test.want {
+			t.Errorf("%s %q: got %q, want %q", test.encoding, test.input, got, test.want)
+		}
+	}
+}
+
+func TestDecodeTextUnknownEncoding(t *testing.T) {
+	_, err := decodeText(models.Rule{Encoding: "ebcdic"}, bufio.NewReader(strings.NewReader("")))
+	if err == nil || err.Error() != "unknown encoding: ebcdic" {
+		t.Errorf("error = %v, want unknown encoding", err)
+	}
+}
@@ -0,0 +1,193 @@
+package pipeline
+
+import (
+	"datenkarte/internal/models"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+var (
+	// builtinDateFormats are the ids of the built-in number formats showing
+	// a date or time
+	builtinDateFormats = map[int]bool{
+		14: true, 15: true, 16: true, 17: true, 18: true, 19: true, 20: true, 21: true, 22: true,
+		27: true, 28: true, 29: true, 30: true, 31: true, 32: true, 33: true, 34: true, 35: true, 36: true,
+		45: true, 46: true, 47: true,
+		50: true, 51: true, 52: true, 53: true, 54: true, 55: true, 56: true, 57: true, 58: true,
+	}
+
+	// formatLiterals are the quoted, escaped and bracketed parts of a number
+	// format, which are not placeholders
+	formatLiterals = regexp.MustCompile(`"[^"]*"|\\.|\[[^\]]*\]`)
+)
+
+// excelReader reads the rows of one sheet of a workbook as records. Empty
+// rows are skipped and shorter rows padded to the width of the first row.
+type excelReader struct {
+	file       *excelize.File
+	sheet      string
+	rows       *excelize.Rows
+	row        int
+	width      int
+	raw        bool
+	dateLayout string
+	date1904   bool
+	dateStyles map[int]bool
+}
+
+func newExcelReader(rule models.Rule, src io.Reader) (*excelReader, error) {
+	file, err := excelize.OpenReader(src)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open workbook: %v", err)
+	}
+
+	sheet, err := sheetOf(file, rule.Excel)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	rows, err := file.Rows(sheet)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	r := &excelReader{
+		file:       file,
+		sheet:      sheet,
+		rows:       rows,
+		raw:        rule.Excel.Raw,
+		dateLayout: rule.Excel.DateLayout,
+		dateStyles: make(map[int]bool),
+	}
+	if props, err := file.GetWorkbookProps(); err == nil && props.Date1904 != nil {
+		r.date1904 = *props.Date1904
+	}
+
+	// Rows above the header row are skipped
+	for r.row < rule.Excel.HeaderRow-1 && rows.Next() {
+		r.row++
+	}
+	return r, nil
+}
+
+// sheetOf returns the sheet selected by name or 1-based index, or the first
+// sheet of the workbook.
+func sheetOf(file *excelize.File, config models.Excel) (string, error) {
+	sheets := file.GetSheetList()
+	switch {
+	case config.Sheet != "":
+		if index, err := file.GetSheetIndex(config.Sheet); err != nil || index < 0 {
+			return "", fmt.Errorf("sheet %s not found", config.Sheet)
+		}
+		return config.Sheet, nil
+	case config.SheetIndex > 0:
+		if config.SheetIndex > len(sheets) {
+			return "", fmt.Errorf("sheet %d not found, the workbook has %d sheets", config.SheetIndex, len(sheets))
+		}
+		return sheets[config.SheetIndex-1], nil
+	case len(sheets) == 0:
+		return "", fmt.Errorf("workbook has no sheets")
+	}
+	return sheets[0], nil
+}
+
+func (r *excelReader) Read() ([]string, error) {
+	for r.rows.Next() {
+		r.row++
+		cells, err := r.rows.Columns(excelize.Options{RawCellValue: r.raw})
+		if err != nil {
+			return nil, err
+		}
+		if isEmptyRecord(cells) {
+			continue
+		}
+		if r.dateLayout != "" {
+			if err := r.formatDates(cells); err != nil {
+				return nil, err
+			}
+		}
+
+		if r.width == 0 {
+			r.width = len(cells)
+		}
+		for len(cells) < r.width {
+			cells = append(cells, "")
+		}
+		return cells, nil
+	}
+	if err := r.rows.Error(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+func (r *excelReader) Close() error {
+	r.rows.Close()
+	return r.file.Close()
+}
+
+// formatDates writes the cells with a date format in the configured layout.
+func (r *excelReader) formatDates(cells []string) error {
+	for i, cell := range cells {
+		if cell == "" {
+			continue
+		}
+		name, err := excelize.CoordinatesToCellName(i+1, r.row)
+		if err != nil {
+			return err
+		}
+		style, err := r.file.GetCellStyle(r.sheet, name)
+		if err != nil {
+			return err
+		}
+		if !r.isDateStyle(style) {
+			continue
+		}
+
+		raw, err := r.file.GetCellValue(r.sheet, name, excelize.Options{RawCellValue: true})
+		if err != nil {
+			return err
+		}
+		serial, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			continue
+		}
+		t, err := excelize.ExcelDateToTime(serial, r.date1904)
+		if err != nil {
+			continue
+		}
+		cells[i] = t.Format(r.dateLayout)
+	}
+	return nil
+}
+
+func (r *excelReader) isDateStyle(id int) bool {
+	if isDate, exists := r.dateStyles[id]; exists {
+		return isDate
+	}
+	isDate := false
+	if style, err := r.file.GetStyle(id); err == nil {
+		isDate = builtinDateFormats[style.NumFmt]
+		if style.CustomNumFmt != nil {
+			code := strings.ToLower(formatLiterals.ReplaceAllString(*style.CustomNumFmt, ""))
+			isDate = strings.ContainsAny(code, "ydhs")
+		}
+	}
+	r.dateStyles[id] = isDate
+	return isDate
+}
+
+func isEmptyRecord(cells []string) bool {
+	for _, cell := range cells {
+		if strings.TrimSpace(cell) != "" {
+			return false
+		}
+	}
+	return true
+}
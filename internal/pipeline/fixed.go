@@ -0,0 +1,193 @@
+package pipeline
+
+import (
+	"bufio"
+	"datenkarte/internal/models"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	TrimBoth  = "both"
+	TrimLeft  = "left"
+	TrimRight = "right"
+	TrimNone  = "none"
+
+	defaultRecordTypeName = "Record type"
+	maxLineLength         = 1 << 20
+)
+
+// fixedReader reads fixed-width text lines as records. The headers are the
+// record type column followed by the columns of every record type in the
+// order of the configuration; a line only fills the columns of its type.
+type fixedReader struct {
+	config  models.FixedWidth
+	scanner *bufio.Scanner
+	headers []string
+	columns map[string]int
+	records map[string]models.FixedRecord
+	started bool
+	line    int
+}
+
+func newFixedReader(rule models.Rule, src io.Reader) (*fixedReader, error) {
+	config := rule.FixedWidth
+	records := config.Records
+	if len(records) == 0 {
+		records = []models.FixedRecord{{Columns: config.Columns}}
+	}
+	if err := checkTrim(config.Trim); err != nil {
+		return nil, err
+	}
+	if len(records) > 1 && config.RecordType == nil {
+		return nil, fmt.Errorf("fixed_width with several records needs a record_type")
+	}
+
+	r := &fixedReader{
+		config:  config,
+		scanner: bufio.NewScanner(src),
+		columns: make(map[string]int),
+		records: make(map[string]models.FixedRecord, len(records)),
+	}
+	r.scanner.Buffer(make([]byte, 0, 64*1024), maxLineLength)
+
+	if config.RecordType != nil {
+		if err := checkFixedColumn(*config.RecordType); err != nil {
+			return nil, err
+		}
+		r.addHeader(recordTypeName(config))
+	}
+	for _, record := range records {
+		if _, exists := r.records[record.Type]; exists {
+			return nil, fmt.Errorf("duplicate fixed_width record type %q", record.Type)
+		}
+		r.records[record.Type] = record
+		for _, column := range record.Columns {
+			if err := checkFixedColumn(column); err != nil {
+				return nil, err
+			}
+			r.addHeader(column.Name)
+		}
+	}
+	return r, nil
+}
+
+func recordTypeName(config models.FixedWidth) string {
+	if config.RecordType.Name == "" {
+		return defaultRecordTypeName
+	}
+	return config.RecordType.Name
+}
+
+func checkFixedColumn(column models.FixedColumn) error {
+	if err := checkTrim(column.Trim); err != nil {
+		return err
+	}
+	if column.Start < 1 {
+		return fmt.Errorf("fixed_width column %s needs a start of at least 1", column.Name)
+	}
+	if column.Length < 1 && column.End < column.Start {
+		return fmt.Errorf("fixed_width column %s needs a length or an end after its start", column.Name)
+	}
+	return nil
+}
+
+func checkTrim(trim string) error {
+	switch trim {
+	case "", TrimBoth, TrimLeft, TrimRight, TrimNone:
+		return nil
+	}
+	return fmt.Errorf("unknown fixed_width trim: %s", trim)
+}
+
+// addHeader adds a column unless a record type before already has it.
+func (r *fixedReader) addHeader(name string) {
+	if _, exists := r.columns[name]; !exists {
+		r.columns[name] = len(r.headers)
+		r.headers = append(r.headers, name)
+	}
+}
+
+func (r *fixedReader) Read() ([]string, error) {
+	if !r.started {
+		r.started = true
+		return append([]string(nil), r.headers...), nil
+	}
+
+	for r.scanner.Scan() {
+		r.line++
+		line := []rune(strings.TrimRight(r.scanner.Text(), "\r"))
+		if strings.TrimSpace(string(line)) == "" {
+			continue
+		}
+
+		recordType := ""
+		if r.config.RecordType != nil {
+			recordType = r.cut(line, *r.config.RecordType)
+		}
+		record, exists := r.records[recordType]
+		if !exists {
+			// A record without type takes lines of every other type
+			record, exists = r.records[""]
+		}
+		if !exists {
+			return nil, fmt.Errorf("line %d has unknown record type %q", r.line, recordType)
+		}
+
+		values := make([]string, len(r.headers))
+		if r.config.RecordType != nil {
+			values[r.columns[recordTypeName(r.config)]] = recordType
+		}
+		for _, column := range record.Columns {
+			values[r.columns[column.Name]] = r.cut(line, column)
+		}
+		return values, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+// cut returns the trimmed text of the column in the line. Positions beyond
+// the end of the line read as empty. Without trim padded values are only
+// trimmed on the left.
+func (r *fixedReader) cut(line []rune, column models.FixedColumn) string {
+	end := column.Start - 1 + column.Length
+	if column.Length < 1 {
+		end = column.End
+	}
+	start := column.Start - 1
+	if start > len(line) {
+		start = len(line)
+	}
+	if end > len(line) {
+		end = len(line)
+	}
+	value := string(line[start:end])
+
+	trim, pad := column.Trim, column.Pad
+	if trim == "" {
+		trim = r.config.Trim
+	}
+	if pad == "" {
+		pad = r.config.Pad
+	}
+	if trim == "" && pad != "" {
+		// Trimming zeros on the right would change padded numbers
+		trim = TrimLeft
+	}
+	if pad == "" {
+		pad = " "
+	}
+	switch trim {
+	case "", TrimBoth:
+		return strings.Trim(value, pad)
+	case TrimLeft:
+		return strings.TrimLeft(value, pad)
+	case TrimRight:
+		return strings.TrimRight(value, pad)
+	}
+	return value
+}
@@ -0,0 +1,71 @@
+package pipeline
+
+import (
+	"datenkarte/internal/models"
+	"testing"
+)
+
+func TestFixedReaderTrim(t *testing.T) {
+	tests := []struct {
+		name   string
+		config models.FixedWidth
+		column models.FixedColumn
+		line   string
+		want   string
+	}{
+		{
+			name: "both sides by default",
+			line: "  ab  ",
+			want: "ab",
+		},
+		{
+			name:   "pad trims left by default",
+			column: models.FixedColumn{Pad: "0"},
+			line:   "001000",
+			want:   "1000",
+		},
+		{
+			name:   "pad of all columns trims left by default",
+			config: models.FixedWidth{Pad: "0"},
+			line:   "001000",
+			want:   "1000",
+		},
+		{
+			name:   "pad with trim both",
+			column: models.FixedColumn{Pad: "0", Trim: TrimBoth},
+			line:   "001000",
+			want:   "1",
+		},
+		{
+			name:   "trim of all columns applies to pad",
+			config: models.FixedWidth{Trim: TrimRight},
+			column: models.FixedColumn{Pad: "0"},
+			line:   "001000",
+			want:   "001",
+		},
+		{
+			name:   "no trim",
+			column: models.FixedColumn{Trim: TrimNone},
+			line:   " ab ",
+			want:   " ab ",
+		},
+		{
+			name: "beyond the end of the line",
+			line: "ab",
+			want: "ab",
+		},
+	}
+	for _, test := range tests {
+		test.column.Name = "Value"
+		test.column.Start = 1
+		test.column.Length = 6
+		test.config.Columns = []models.FixedColumn{test.column}
+		r, err := newFixedReader(models.Rule{FixedWidth: test.config}, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if got := r.cut([]rune(test.line), test.column); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
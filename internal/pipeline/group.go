@@ -0,0 +1,89 @@
+package pipeline
+
+import (
+	"datenkarte/internal/mapping"
+	"datenkarte/internal/models"
+	"datenkarte/internal/validation"
+	"fmt"
+	"strings"
+)
+
+const (
+	GroupConsecutive = "consecutive"
+	GroupAll         = "all"
+)
+
+// grouper collects the payloads of rows with the same group_by key into one
+// payload. In consecutive mode a group ends at the first row with another
+// key, in all mode every group is held until the end of the file.
+type grouper struct {
+	rule   models.Rule
+	sink   Sink
+	all    bool
+	keys   []string
+	groups map[string]map[string]interface{}
+}
+
+// newGrouper returns nil if the rule does not group rows.
+func newGrouper(rule models.Rule, sink Sink) (*grouper, error) {
+	if len(rule.GroupBy) == 0 {
+		return nil, nil
+	}
+	switch rule.GroupMode {
+	case "", GroupConsecutive, GroupAll:
+	default:
+		return nil, fmt.Errorf("unknown group_mode: %s", rule.GroupMode)
+	}
+	return &grouper{
+		rule:   rule,
+		sink:   sink,
+		all:    rule.GroupMode == GroupAll,
+		groups: make(map[string]map[string]interface{}),
+	}, nil
+}
+
+// groupKey joins the group_by columns of a row, it is empty for rules that
+// do not group rows.
+func groupKey(rule models.Rule, line []string, headers []string) string {
+	if len(rule.GroupBy) == 0 {
+		return ""
+	}
+	row := validation.NewRow(line, headers)
+	values := make([]string, len(rule.GroupBy))
+	for i, column := range rule.GroupBy {
+		values[i] = row[column]
+	}
+	return strings.Join(values, "\x00")
+}
+
+// add collects the payload of the row at index into the group with the given
+// key, writing the previous group to the sink when a consecutive group ends.
+func (g *grouper) add(index int, key string, payload map[string]interface{}) error {
+	if group, exists := g.groups[key]; exists {
+		if err := mapping.MergeGroup(group, payload, g.rule); err != nil {
+			return &Error{Stage: StageMapping, Row: index + 1, Err: err}
+		}
+		return nil
+	}
+	if !g.all {
+		if err := g.flush(); err != nil {
+			return &Error{Stage: StageDelivery, Row: index + 1, Err: err}
+		}
+	}
+	g.keys = append(g.keys, key)
+	g.groups[key] = payload
+	return nil
+}
+
+// flush writes the collected groups to the sink in the order of their
+// first row.
+func (g *grouper) flush() error {
+	for _, key := range g.keys {
+		if err := g.sink.Write(g.groups[key]); err != nil {
+			return err
+		}
+	}
+	g.keys = nil
+	g.groups = make(map[string]map[string]interface{})
+	return nil
+}
@@ -0,0 +1,58 @@
+package pipeline
+
+import (
+	"bufio"
+	"bytes"
+	"datenkarte/internal/models"
+	"fmt"
+	"io"
+)
+
+// zipMagic starts every .xlsx file
+var zipMagic = []byte("PK\x03\x04")
+
+// recordReader returns the records of an uploaded file one by one and
+// io.EOF after the last one.
+type recordReader interface {
+	Read() ([]string, error)
+}
+
+const (
+	FormatCSV    = "csv"
+	FormatXLSX   = "xlsx"
+	FormatJSON   = "json"
+	FormatJSONL  = "jsonl"
+	FormatNDJSON = "ndjson"
+	FormatFixed  = "fixed"
+)
+
+// newRecordReader returns a reader for the rule's input format. Without a
+// format the file is read as CSV unless its first bytes show an Excel
+// workbook.
+func newRecordReader(rule models.Rule, src io.Reader) (recordReader, error) {
+	buffered := bufio.NewReaderSize(src, sniffLength)
+	switch rule.Format {
+	case "", FormatCSV, FormatJSON, FormatJSONL, FormatNDJSON, FormatFixed:
+	case FormatXLSX:
+		return newExcelReader(rule, buffered)
+	default:
+		return nil, fmt.Errorf("unknown format: %s", rule.Format)
+	}
+	if magic, _ := buffered.Peek(len(zipMagic)); rule.Format == "" && bytes.Equal(magic, zipMagic) {
+		return newExcelReader(rule, buffered)
+	}
+
+	// Text formats are converted to UTF-8 first
+	text, err := decodeText(rule, buffered)
+	if err != nil {
+		return nil, err
+	}
+	switch rule.Format {
+	case FormatJSON, FormatJSONL, FormatNDJSON:
+		return newJSONReader(rule, text)
+	case FormatFixed:
+		return newFixedReader(rule, text)
+	}
+
+	return newCSVReader(rule, text)
+}
@@ -0,0 +1,195 @@
+package pipeline
+
+import (
+	"bufio"
+	"bytes"
+	"datenkarte/internal/models"
+	"datenkarte/internal/validation"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// jsonReader reads a JSON array of objects, or objects one after another as
+// in JSON Lines, as records. The headers are the dot paths of the fields of
+// the first object followed by the other columns the rule refers to, and
+// every record holds the values at these paths as strings.
+type jsonReader struct {
+	rule    models.Rule
+	decoder *json.Decoder
+	array   bool
+	headers []string
+	pending map[string]interface{}
+}
+
+func newJSONReader(rule models.Rule, src *bufio.Reader) (*jsonReader, error) {
+	decoder := json.NewDecoder(src)
+	decoder.UseNumber()
+	r := &jsonReader{rule: rule, decoder: decoder}
+
+	// A top level array is streamed element by element
+	if first, err := firstByte(src); err == nil && first == '[' {
+		if _, err := decoder.Token(); err != nil {
+			return nil, err
+		}
+		r.array = true
+	}
+	return r, nil
+}
+
+// firstByte returns the first byte of src that is not white space without
+// consuming it.
+func firstByte(src *bufio.Reader) (byte, error) {
+	for i := 1; ; i++ {
+		peeked, err := src.Peek(i)
+		if len(peeked) < i {
+			return 0, err
+		}
+		if b := peeked[i-1]; !bytes.ContainsRune([]byte(" \t\r\n"), rune(b)) {
+			return b, nil
+		}
+	}
+}
+
+func (r *jsonReader) next() (map[string]interface{}, error) {
+	if r.array && !r.decoder.More() {
+		return nil, io.EOF
+	}
+	var record interface{}
+	if err := r.decoder.Decode(&record); err != nil {
+		return nil, err
+	}
+	object, ok := record.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected a JSON object, got %s", jsonType(record))
+	}
+	return object, nil
+}
+
+func (r *jsonReader) Read() ([]string, error) {
+	if r.headers == nil {
+		first, err := r.next()
+		if err != nil {
+			return nil, err
+		}
+		r.pending = first
+		r.headers = jsonHeaders(first, r.rule)
+		return append([]string(nil), r.headers...), nil
+	}
+
+	record := r.pending
+	r.pending = nil
+	if record == nil {
+		var err error
+		if record, err = r.next(); err != nil {
+			return nil, err
+		}
+	}
+
+	values := make([]string, len(r.headers))
+	for i, header := range r.headers {
+		values[i] = jsonString(jsonPath(record, header))
+	}
+	return values, nil
+}
+
+// jsonHeaders returns the paths of the leaf fields of the object, with
+// nested objects joined by dots, and then the columns of the rule missing
+// in the object.
+func jsonHeaders(object map[string]interface{}, rule models.Rule) []string {
+	var headers []string
+	var walk func(prefix string, object map[string]interface{})
+	walk = func(prefix string, object map[string]interface{}) {
+		keys := make([]string, 0, len(object))
+		for key := range object {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if nested, ok := object[key].(map[string]interface{}); ok && len(nested) > 0 {
+				walk(prefix+key+".", nested)
+				continue
+			}
+			headers = append(headers, prefix+key)
+		}
+	}
+	walk("", object)
+
+	present := make(map[string]bool, len(headers))
+	for _, header := range headers {
+		present[header] = true
+	}
+	var missing []string
+	for name := range validation.KnownHeaders(rule) {
+		if !present[name] {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+	return append(headers, missing...)
+}
+
+// jsonPath returns the value at the dot path in object, preferring keys
+// that contain dots themselves.
+func jsonPath(object map[string]interface{}, path string) interface{} {
+	if value, exists := object[path]; exists {
+		return value
+	}
+	for i := strings.Index(path, "."); i >= 0; i = nextDot(path, i) {
+		if nested, ok := object[path[:i]].(map[string]interface{}); ok {
+			if value := jsonPath(nested, path[i+1:]); value != nil {
+				return value
+			}
+		}
+	}
+	return nil
+}
+
+func nextDot(path string, i int) int {
+	next := strings.Index(path[i+1:], ".")
+	if next < 0 {
+		return -1
+	}
+	return i + 1 + next
+}
+
+// jsonString converts a JSON value to the string a CSV cell would hold.
+// Arrays and objects are kept as JSON text and null becomes empty.
+func jsonString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		if v {
+			return "true"
+		}
+		return "false"
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(encoded)
+}
+
+func jsonType(value interface{}) string {
+	switch value.(type) {
+	case []interface{}:
+		return "an array"
+	case string:
+		return "a string"
+	case json.Number:
+		return "a number"
+	case bool:
+		return "a boolean"
+	case nil:
+		return "null"
+	}
+	return fmt.Sprintf("%T", value)
+}
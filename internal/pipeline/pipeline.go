@@ -0,0 +1,387 @@
+package pipeline
+
+import (
+	"datenkarte/internal/mapping"
+	"datenkarte/internal/models"
+	"datenkarte/internal/plugins"
+	"datenkarte/internal/validation"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Stages an upload can fail in
+const (
+	StageParse      = "parse"
+	StageValidation = "validation"
+	StageMapping    = "mapping"
+	StagePlugin     = "plugin"
+	StageDelivery   = "delivery"
+)
+
+// What to do with rows failing validation or mapping
+const (
+	OnInvalidAbort  = "abort"
+	OnInvalidSkip   = "skip"
+	OnInvalidReport = "report"
+)
+
+// maxReportedErrors limits the row errors kept in a Result, further
+// failures are only counted.
+const maxReportedErrors = 1000
+
+// Error reports the stage and row an upload failed in
+type Error struct {
+	Stage string
+	Row   int
+	Err   error
+}
+
+func (e *Error) Error() string {
+	if e.Row > 0 {
+		return fmt.Sprintf("%s failed at row %d: %v", e.Stage, e.Row, e.Err)
+	}
+	return fmt.Sprintf("%s failed: %v", e.Stage, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Sink receives mapped payloads one row at a time. Close delivers whatever
+// is still pending, Discard drops it after a failed run.
+type Sink interface {
+	Write(payload map[string]interface{}) error
+	Close() error
+	Discard()
+}
+
+// Result summarizes a processed upload
+type Result struct {
+	ProcessedRows int                     `json:"processed_rows"`
+	FailedRows    int                     `json:"failed_rows"`
+	Errors        []validation.FieldError `json:"errors,omitempty"`
+}
+
+// fail records the errors of a rejected row.
+func (r *Result) fail(row int, err error) {
+	r.FailedRows++
+
+	var errs validation.Errors
+	if !errors.As(err, &errs) {
+		errs = validation.Errors{{Rule: StageMapping, Message: err.Error()}}
+	}
+	for _, e := range errs {
+		if len(r.Errors) >= maxReportedErrors {
+			return
+		}
+		e.Row = row
+		r.Errors = append(r.Errors, e)
+	}
+}
+
+// fileErrors records the violations of a failed header check, which are not
+// tied to a row.
+func (r *Result) fileErrors(err error) {
+	var pipelineErr *Error
+	var errs validation.Errors
+	if errors.As(err, &pipelineErr) && pipelineErr.Row == 0 && errors.As(pipelineErr.Err, &errs) {
+		r.Errors = append(r.Errors, errs...)
+	}
+}
+
+// Run streams the CSV in src row by row through validation and mapping and
+// hands every payload to sink, so only the current row is held in memory.
+// The sink is closed after the EXIT_RULE hook once all rows are processed.
+//
+// Invalid rows are handled according to the rule's on_invalid: abort stops
+// at the first one, skip leaves them out and report checks and maps the
+// whole file first, keeping the payloads in a temporary file, and delivers
+// nothing if any row is invalid.
+// The headers are checked before the first row, row count bounds and
+// unique columns in a first pass over the file before any delivery.
+//
+// Rules with group_by deliver one payload per group of rows instead.
+//
+// Rejected rows are written to rejects, which may be nil.
+func Run(rule models.Rule, pm *plugins.PluginManager, src io.ReadSeeker, sink Sink, rejects *RejectWriter) (result *Result, err error) {
+	defer func() {
+		if err != nil {
+			sink.Discard()
+		}
+	}()
+
+	// Execute ENTER_RULE hook
+	ruleData := map[string]interface{}{
+		"rule_id": rule.ID,
+		"type":    rule.Type,
+	}
+
+	if _, err := pm.ExecuteHook(plugins.ENTER_RULE, ruleData); err != nil {
+		return nil, &Error{Stage: StagePlugin, Err: err}
+	}
+
+	onInvalid := rule.OnInvalid
+	if onInvalid == "" {
+		onInvalid = OnInvalidAbort
+	}
+
+	switch onInvalid {
+	case OnInvalidAbort, OnInvalidSkip, OnInvalidReport:
+	default:
+		return nil, &Error{Stage: StageValidation, Err: fmt.Errorf("unknown on_invalid: %s", onInvalid)}
+	}
+
+	groups, err := newGrouper(rule, sink)
+	if err != nil {
+		return nil, &Error{Stage: StageMapping, Err: err}
+	}
+
+	if rule, err = resolveDelimiter(rule, src); err != nil {
+		return nil, &Error{Stage: StageParse, Err: err}
+	}
+	rejects.setDelimiter(delimiterOf(rule))
+
+	result = &Result{}
+	defer func() {
+		if err != nil {
+			result.fileErrors(err)
+		}
+	}()
+
+	deliver := func(index int, group string, payload map[string]interface{}) error {
+		if groups != nil {
+			if err := groups.add(index, group, payload); err != nil {
+				return err
+			}
+		} else if err := sink.Write(payload); err != nil {
+			return &Error{Stage: StageDelivery, Row: index + 1, Err: err}
+		}
+		result.ProcessedRows++
+		return nil
+	}
+
+	unique := validation.NewUniqueness(rule)
+	if onInvalid == OnInvalidReport {
+		spool, err := newPayloadSpool()
+		if err != nil {
+			return result, &Error{Stage: StageDelivery, Err: err}
+		}
+		defer spool.Close()
+
+		if err := precheck(rule, src, unique, func(index int, line []string, headers []string, raw []string) error {
+			payload, rowErr := processRow(rule, pm, unique, index, line, headers)
+			if rowErr != nil {
+				result.fail(index+1, rowErr.Err)
+				if err := rejects.Write(raw, line, rowErr.Err); err != nil {
+					return &Error{Stage: rowErr.Stage, Row: index + 1, Err: err}
+				}
+				return nil
+			}
+			if err := spool.Write(index, groupKey(rule, line, headers), payload); err != nil {
+				return &Error{Stage: StageMapping, Row: index + 1, Err: err}
+			}
+			return nil
+		}); err != nil {
+			return result, err
+		}
+		if result.FailedRows > 0 {
+			return result, &Error{Stage: StageValidation, Err: fmt.Errorf("%d rows failed validation or mapping", result.FailedRows)}
+		}
+
+		err = spool.Each(deliver)
+	} else {
+		if unique != nil || rule.File.MinRows > 0 || rule.File.MaxRows > 0 {
+			if err := precheck(rule, src, unique, nil); err != nil {
+				return result, err
+			}
+			if _, err := src.Seek(0, io.SeekStart); err != nil {
+				return result, &Error{Stage: StageParse, Err: err}
+			}
+		}
+
+		err = readRows(rule, src, func(index int, line []string, headers []string, raw []string) error {
+			payload, rowErr := processRow(rule, pm, unique, index, line, headers)
+			if rowErr != nil {
+				result.fail(index+1, rowErr.Err)
+				if err := rejects.Write(raw, line, rowErr.Err); err != nil {
+					return &Error{Stage: rowErr.Stage, Row: index + 1, Err: err}
+				}
+				if onInvalid == OnInvalidSkip {
+					return nil
+				}
+				return rowErr
+			}
+			return deliver(index, groupKey(rule, line, headers), payload)
+		})
+	}
+	if err != nil {
+		return result, err
+	}
+	if groups != nil {
+		if err := groups.flush(); err != nil {
+			return result, &Error{Stage: StageDelivery, Err: err}
+		}
+	}
+
+	// Execute EXIT_RULE hook before the last batch is sent
+	exitData := map[string]interface{}{
+		"rule_id":        rule.ID,
+		"processed_rows": result.ProcessedRows,
+		"failed_rows":    result.FailedRows,
+	}
+
+	if _, err := pm.ExecuteHook(plugins.EXIT_RULE, exitData); err != nil {
+		return result, &Error{Stage: StagePlugin, Err: err}
+	}
+
+	if err := sink.Close(); err != nil {
+		return result, &Error{Stage: StageDelivery, Err: err}
+	}
+
+	return result, nil
+}
+
+// precheck reads the whole file before anything is delivered to check the
+// row count bounds and collect the values of unique columns. fn, if set, is
+// called for every row after its values are collected.
+func precheck(rule models.Rule, src io.Reader, unique *validation.Uniqueness, fn func(index int, line []string, headers []string, raw []string) error) error {
+	rows := 0
+	err := readRows(rule, src, func(index int, line []string, headers []string, raw []string) error {
+		rows++
+		if rule.File.MaxRows > 0 && rows > rule.File.MaxRows {
+			return &Error{Stage: StageValidation, Err: fmt.Errorf("file must contain at most %d rows", rule.File.MaxRows)}
+		}
+
+		unique.Add(index, validation.NewRow(line, headers))
+		if fn == nil {
+			return nil
+		}
+		return fn(index, line, headers, raw)
+	})
+	if err != nil {
+		return err
+	}
+
+	if rows < rule.File.MinRows {
+		return &Error{Stage: StageValidation, Err: fmt.Errorf("file must contain at least %d rows, got %d", rule.File.MinRows, rows)}
+	}
+	return nil
+}
+
+// processRow validates and maps a row. The error tells the stage the row
+// failed in.
+func processRow(rule models.Rule, pm *plugins.PluginManager, unique *validation.Uniqueness, index int, line []string, headers []string) (map[string]interface{}, *Error) {
+	if err := validateRow(rule, unique, index, line, headers); err != nil {
+		return nil, &Error{Stage: StageValidation, Row: index + 1, Err: err}
+	}
+
+	payload, err := mapping.MapLineToJSON(line, headers, rule, index, pm)
+	if err != nil {
+		return nil, &Error{Stage: StageMapping, Row: index + 1, Err: err}
+	}
+	return payload, nil
+}
+
+// validateRow runs the line validations and the uniqueness check of a row
+// and returns all violations as validation.Errors.
+func validateRow(rule models.Rule, unique *validation.Uniqueness, index int, line []string, headers []string) error {
+	var errs validation.Errors
+	for _, err := range []error{
+		validation.ValidateLine(line, headers, rule),
+		unique.Check(index, validation.NewRow(line, headers)),
+	} {
+		var rowErrs validation.Errors
+		if errors.As(err, &rowErrs) {
+			errs = append(errs, rowErrs...)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// readRows parses the CSV or Excel file in src and calls fn for every row
+// after the header with its 0-based index. The headers are matched to the
+// names used in the rule first, raw are the headers as they are in the file.
+func readRows(rule models.Rule, src io.Reader, fn func(index int, line []string, headers []string, raw []string) error) error {
+	reader, err := newRecordReader(rule, src)
+	if err != nil {
+		return &Error{Stage: StageParse, Err: err}
+	}
+	if closer, ok := reader.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	first, err := reader.Read()
+	if err != nil {
+		return &Error{Stage: StageParse, Err: err}
+	}
+
+	// Headerless files name their columns by position and have no raw
+	// headers
+	raw, names := first, first
+	if rule.Headerless {
+		raw = nil
+		names = make([]string, len(first))
+		for i := range names {
+			names[i] = fmt.Sprintf("Column %d", i+1)
+		}
+	}
+	headers, err := validation.CanonicalHeaders(names, rule)
+	if err != nil {
+		return &Error{Stage: StageValidation, Err: err}
+	}
+	if err := validation.ValidateHeaders(headers, rule); err != nil {
+		return &Error{Stage: StageValidation, Err: err}
+	}
+
+	index := 0
+	if rule.Headerless {
+		if err := fn(index, first, headers, raw); err != nil {
+			return err
+		}
+		index++
+	}
+
+	for ; ; index++ {
+		line, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return &Error{Stage: StageParse, Row: index + 1, Err: err}
+		}
+		if err := fn(index, line, headers, raw); err != nil {
+			return err
+		}
+	}
+}
+
+// delimiterOf returns the CSV delimiter of the rule, ";" by default and
+// until auto detection resolved it.
+func delimiterOf(rule models.Rule) rune {
+	if rule.Delimiter == "" || rule.Delimiter == DelimiterAuto {
+		return ';'
+	}
+	return []rune(rule.Delimiter)[0]
+}
+
+// Collector is a Sink keeping all payloads in memory, used for dry runs
+type Collector struct {
+	Payloads []map[string]interface{}
+}
+
+func (c *Collector) Write(payload map[string]interface{}) error {
+	c.Payloads = append(c.Payloads, payload)
+	return nil
+}
+
+func (c *Collector) Close() error {
+	return nil
+}
+
+func (c *Collector) Discard() {
+	c.Payloads = nil
+}
@@ -0,0 +1,425 @@
+package pipeline
+
+import (
+	"datenkarte/internal/models"
+	"datenkarte/internal/plugins"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const people = "Name;Age;Note\nAnn;41;a\nBob;x;b\nCid;30;\nDan;25;d\n"
+
+// peopleRule maps the people CSV. Bob fails validation and Cid fails
+// mapping, because an empty note is filled with a value that is not a
+// boolean.
+func peopleRule(onInvalid string) models.Rule {
+	return models.Rule{
+		ID:        "people",
+		OnInvalid: onInvalid,
+		EachLine: []models.EachLine{{
+			Map: []models.Mapping{
+				{Name: "Name", To: "name"},
+				{Name: "Age", To: "age"},
+				{Name: "Note", To: "note", Fill: &models.Fill{Type: "boolean", Value: "maybe"}},
+				{Name: "Row", To: "row", Fill: &models.Fill{Type: "number", Value: "row_number"}},
+			},
+			Validation: []models.Validation{{Field: "Age", Type: "number"}},
+		}},
+	}
+}
+
+func person(name, age, note string, row int) map[string]interface{} {
+	return map[string]interface{}{"name": name, "age": age, "note": note, "row": row}
+}
+
+func run(t *testing.T, rule models.Rule, csv string) (*Collector, *Result, error) {
+	t.Helper()
+	collector := &Collector{}
+	result, err := Run(rule, plugins.NewPluginManager(), strings.NewReader(csv), collector, nil)
+	return collector, result, err
+}
+
+// runRejecting runs the rule like run and returns the rejected rows file,
+// which is empty if no row was rejected.
+func runRejecting(t *testing.T, rule models.Rule, csv string) (*Result, string, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "rejected.csv")
+	rejects, err := CreateRejects(path, rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := Run(rule, plugins.NewPluginManager(), strings.NewReader(csv), &Collector{}, rejects)
+	if err := rejects.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, readErr := os.ReadFile(path)
+	if readErr != nil && !os.IsNotExist(readErr) {
+		t.Fatal(readErr)
+	}
+	return result, string(data), err
+}
+
+func failedRows(result *Result) []int {
+	var rows []int
+	for _, e := range result.Errors {
+		rows = append(rows, e.Row)
+	}
+	return rows
+}
+
+func TestRunOnInvalidAbort(t *testing.T) {
+	collector, result, err := run(t, peopleRule(""), people)
+
+	var pipelineErr *Error
+	if !errors.As(err, &pipelineErr) || pipelineErr.Stage != StageValidation || pipelineErr.Row != 2 {
+		t.Fatalf("error = %v, want validation failed at row 2", err)
+	}
+	if collector.Payloads != nil {
+		t.Errorf("payloads = %v, want discarded", collector.Payloads)
+	}
+	if result.ProcessedRows != 1 || result.FailedRows != 1 || !reflect.DeepEqual(failedRows(result), []int{2}) {
+		t.Errorf("result = %+v", result)
+	}
+}
+
+func TestRunOnInvalidSkip(t *testing.T) {
+	collector, result, err := run(t, peopleRule(OnInvalidSkip), people)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []map[string]interface{}{person("Ann", "41", "a", 1), person("Dan", "25", "d", 4)}
+	if !reflect.DeepEqual(collector.Payloads, want) {
+		t.Errorf("payloads = %v, want %v", collector.Payloads, want)
+	}
+	if result.ProcessedRows != 2 || result.FailedRows != 2 {
+		t.Errorf("result = %+v", result)
+	}
+	if len(result.Errors) != 2 || result.Errors[0].Rule != "number" || result.Errors[1].Rule != StageMapping {
+		t.Errorf("errors = %+v, want a validation and a mapping error", result.Errors)
+	}
+	if !reflect.DeepEqual(failedRows(result), []int{2, 3}) {
+		t.Errorf("failed rows = %v, want 2 and 3", failedRows(result))
+	}
+}
+
+func TestRunOnInvalidReport(t *testing.T) {
+	collector, result, err := run(t, peopleRule(OnInvalidReport), people)
+
+	var pipelineErr *Error
+	if !errors.As(err, &pipelineErr) || pipelineErr.Stage != StageValidation || pipelineErr.Row != 0 {
+		t.Fatalf("error = %v, want validation failed for the file", err)
+	}
+	if len(collector.Payloads) != 0 {
+		t.Errorf("payloads = %v, want none delivered", collector.Payloads)
+	}
+	if result.ProcessedRows != 0 || result.FailedRows != 2 || !reflect.DeepEqual(failedRows(result), []int{2, 3}) {
+		t.Errorf("result = %+v", result)
+	}
+}
+
+func TestRunOnInvalidReportDeliversValidFile(t *testing.T) {
+	valid := "Name;Age;Note\nAnn;41;a\nDan;25;d\n"
+	report, result, err := run(t, peopleRule(OnInvalidReport), valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ProcessedRows != 2 || result.FailedRows != 0 {
+		t.Errorf("result = %+v", result)
+	}
+
+	// Payloads read back from the spool file keep their types
+	abort, _, err := run(t, peopleRule(OnInvalidAbort), valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(report.Payloads, abort.Payloads) {
+		t.Errorf("report payloads = %#v, want %#v", report.Payloads, abort.Payloads)
+	}
+}
+
+func TestRunUnknownOnInvalid(t *testing.T) {
+	if _, _, err := run(t, peopleRule("ignore"), people); err == nil || !strings.Contains(err.Error(), "unknown on_invalid") {
+		t.Errorf("error = %v, want unknown on_invalid", err)
+	}
+}
+
+func TestRunRejects(t *testing.T) {
+	bob := "Bob;x;b;field Age must be a number, got: x\n"
+	cid := "Cid;30;;fill failed for Note: fill value maybe is not a boolean\n"
+	tests := []struct {
+		onInvalid string
+		want      string
+	}{
+		{OnInvalidAbort, "Name;Age;Note;_errors\n" + bob},
+		{OnInvalidSkip, "Name;Age;Note;_errors\n" + bob + cid},
+		{OnInvalidReport, "Name;Age;Note;_errors\n" + bob + cid},
+	}
+	for _, test := range tests {
+		_, rejected, _ := runRejecting(t, peopleRule(test.onInvalid), people)
+		if rejected != test.want {
+			t.Errorf("%s: rejected %q, want %q", test.onInvalid, rejected, test.want)
+		}
+	}
+}
+
+func TestRunRejectsNothingRejected(t *testing.T) {
+	_, rejected, err := runRejecting(t, peopleRule(OnInvalidSkip), "Name;Age;Note\nAnn;41;a\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rejected != "" {
+		t.Errorf("rejected %q, want no file", rejected)
+	}
+}
+
+func TestRunRejectsReplacesErrorsOfReupload(t *testing.T) {
+	reupload := "Name;_errors;Age;Note\nAnn;old error;41;a\nBob;field Age must be a number, got: x;y;b\n"
+	_, rejected, _ := runRejecting(t, peopleRule(OnInvalidSkip), reupload)
+
+	want := "Name;Age;Note;_errors\nBob;y;b;field Age must be a number, got: y\n"
+	if rejected != want {
+		t.Errorf("rejected %q, want %q", rejected, want)
+	}
+}
+
+func TestRestoreNumbers(t *testing.T) {
+	spool, err := newPayloadSpool()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spool.Close()
+
+	payload := map[string]interface{}{
+		"int":    42,
+		"float":  1.5,
+		"string": "7",
+		"list":   []interface{}{1, 2.25, "x", true},
+		"nested": map[string]interface{}{"n": 3, "empty": nil},
+	}
+	if err := spool.Write(5, "", payload); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []map[string]interface{}
+	if err := spool.Each(func(index int, group string, payload map[string]interface{}) error {
+		if index != 5 {
+			t.Errorf("index = %d, want 5", index)
+		}
+		got = append(got, payload)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || !reflect.DeepEqual(got[0], payload) {
+		t.Errorf("read back %#v, want %#v", got, payload)
+	}
+}
+
+func TestRunFileValidation(t *testing.T) {
+	csv := "Name;Age;Note\nAnn;41;a\nBob;25;b\nAnn;30;c\n"
+	tests := []struct {
+		name      string
+		onInvalid string
+		file      models.FileValidation
+		processed int
+		failed    []int
+		err       string
+	}{
+		{
+			name:      "unique skipped",
+			onInvalid: OnInvalidSkip,
+			file:      models.FileValidation{Unique: []string{"Name"}},
+			processed: 2,
+			failed:    []int{3},
+		},
+		{
+			name:      "unique reported",
+			onInvalid: OnInvalidReport,
+			file:      models.FileValidation{Unique: []string{"Name"}},
+			failed:    []int{3},
+			err:       "1 rows failed validation or mapping",
+		},
+		{
+			name: "max rows",
+			file: models.FileValidation{MaxRows: 2},
+			err:  "file must contain at most 2 rows",
+		},
+		{
+			name:      "min rows",
+			onInvalid: OnInvalidReport,
+			file:      models.FileValidation{MinRows: 4},
+			err:       "file must contain at least 4 rows, got 3",
+		},
+		{
+			name: "required headers",
+			file: models.FileValidation{RequiredHeaders: []string{"Email"}},
+			err:  "Email",
+		},
+	}
+	for _, test := range tests {
+		rule := peopleRule(test.onInvalid)
+		rule.File = test.file
+		collector, result, err := run(t, rule, csv)
+
+		if test.err == "" && err != nil || test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: error = %v, want %q", test.name, err, test.err)
+		}
+		if len(collector.Payloads) != test.processed || result.ProcessedRows != test.processed {
+			t.Errorf("%s: %d payloads, result %+v, want %d processed", test.name, len(collector.Payloads), result, test.processed)
+		}
+		if rows := failedRows(result); test.failed != nil && !reflect.DeepEqual(rows, test.failed) {
+			t.Errorf("%s: failed rows = %v, want %v", test.name, rows, test.failed)
+		}
+	}
+}
+
+func orderRule(groupMode, onInvalid string) models.Rule {
+	return models.Rule{
+		ID:        "orders",
+		OnInvalid: onInvalid,
+		GroupBy:   []string{"Order"},
+		GroupMode: groupMode,
+		EachLine: []models.EachLine{{
+			Map: []models.Mapping{
+				{Name: "Order", To: "order"},
+				{Name: "Customer", To: "customer"},
+				{Name: "SKU", To: "sku", Item: true},
+				{Name: "Quantity", To: "quantity", Type: "integer", Item: true},
+			},
+			Validation: []models.Validation{{Field: "Quantity", Type: "number"}},
+		}},
+	}
+}
+
+func order(id, customer string, items ...map[string]interface{}) map[string]interface{} {
+	list := make([]interface{}, len(items))
+	for i, item := range items {
+		list[i] = item
+	}
+	return map[string]interface{}{"order": id, "customer": customer, "items": list}
+}
+
+func item(sku string, quantity int64) map[string]interface{} {
+	return map[string]interface{}{"sku": sku, "quantity": quantity}
+}
+
+func asJSON(t *testing.T, value interface{}) string {
+	t.Helper()
+	data, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestRunGrouping(t *testing.T) {
+	orders := "Order;Customer;SKU;Quantity\n1;Ann;A;2\n1;Ann;B;1\n2;Bob;C;5\n1;Ann;D;3\n"
+	tests := []struct {
+		name      string
+		groupMode string
+		want      []map[string]interface{}
+	}{
+		{
+			name:      "consecutive",
+			groupMode: GroupConsecutive,
+			want: []map[string]interface{}{
+				order("1", "Ann", item("A", 2), item("B", 1)),
+				order("2", "Bob", item("C", 5)),
+				order("1", "Ann", item("D", 3)),
+			},
+		},
+		{
+			name: "consecutive by default",
+			want: []map[string]interface{}{
+				order("1", "Ann", item("A", 2), item("B", 1)),
+				order("2", "Bob", item("C", 5)),
+				order("1", "Ann", item("D", 3)),
+			},
+		},
+		{
+			name:      "all",
+			groupMode: GroupAll,
+			want: []map[string]interface{}{
+				order("1", "Ann", item("A", 2), item("B", 1), item("D", 3)),
+				order("2", "Bob", item("C", 5)),
+			},
+		},
+	}
+	for _, test := range tests {
+		for _, onInvalid := range []string{OnInvalidAbort, OnInvalidReport} {
+			collector, result, err := run(t, orderRule(test.groupMode, onInvalid), orders)
+			if err != nil {
+				t.Errorf("%s, %s: %v", test.name, onInvalid, err)
+				continue
+			}
+			// Report mode reads the payloads back from its spool file, so
+			// they are compared as delivered
+			if got, want := asJSON(t, collector.Payloads), asJSON(t, test.want); got != want {
+				t.Errorf("%s, %s: payloads = %s, want %s", test.name, onInvalid, got, want)
+			}
+			if result.ProcessedRows != 4 {
+				t.Errorf("%s, %s: %d processed rows, want 4", test.name, onInvalid, result.ProcessedRows)
+			}
+		}
+	}
+}
+
+func TestRunGroupingSkipsInvalidItems(t *testing.T) {
+	orders := "Order;Customer;SKU;Quantity\n1;Ann;A;2\n1;Ann;B;x\n1;Ann;C;1\n"
+	collector, result, err := run(t, orderRule(GroupAll, OnInvalidSkip), orders)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []map[string]interface{}{order("1", "Ann", item("A", 2), item("C", 1))}
+	if !reflect.DeepEqual(collector.Payloads, want) {
+		t.Errorf("payloads = %v, want %v", collector.Payloads, want)
+	}
+	if result.ProcessedRows != 2 || result.FailedRows != 1 {
+		t.Errorf("result = %+v", result)
+	}
+}
+
+func TestRunUnknownGroupMode(t *testing.T) {
+	if _, _, err := run(t, orderRule("sometimes", ""), "Order\n"); err == nil || !strings.Contains(err.Error(), "unknown group_mode") {
+		t.Errorf("error = %v, want unknown group_mode", err)
+	}
+}
+
+func TestRunRejectsKeepFileHeaders(t *testing.T) {
+	rule := peopleRule(OnInvalidSkip)
+	rule.EachLine[0].Map[1].Aliases = []string{"Alter"}
+
+	_, rejected, _ := runRejecting(t, rule, "Name;Alter;Note\nBob;x;b\n")
+	want := "Name;Alter;Note;_errors\nBob;x;b;field Age must be a number, got: x\n"
+	if rejected != want {
+		t.Errorf("rejected %q, want %q", rejected, want)
+	}
+}
+
+func TestRunRejectsHeaderless(t *testing.T) {
+	rule := peopleRule(OnInvalidSkip)
+	rule.Headerless = true
+	rule.EachLine[0].Map[0].Column = 1
+	rule.EachLine[0].Map[1].Column = 2
+	rule.EachLine[0].Map[2].Column = 3
+
+	result, rejected, err := runRejecting(t, rule, "Ann;41;a\nBob;x;b\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ProcessedRows != 1 {
+		t.Errorf("%d processed rows, want 1", result.ProcessedRows)
+	}
+	want := "Bob;x;b;field Age must be a number, got: x\n"
+	if rejected != want {
+		t.Errorf("rejected %q, want %q", rejected, want)
+	}
+}
@@ -0,0 +1,102 @@
+package pipeline
+
+import (
+	"bufio"
+	"datenkarte/internal/models"
+	"encoding/csv"
+	"os"
+)
+
+// RejectsColumn is the column added to rejected rows holding their errors
+const RejectsColumn = "_errors"
+
+// RejectWriter writes rejected rows to a CSV file with the original headers
+// and an _errors column, so users can fix the rows and upload them again.
+// The _errors column of a re-uploaded rejects file is replaced.
+// All methods may be called on a nil writer, which discards the rows.
+type RejectWriter struct {
+	file    *os.File
+	buf     *bufio.Writer
+	csv     *csv.Writer
+	headers bool
+	stale   map[int]bool
+	Rows    int
+}
+
+// CreateRejects creates the file at path using the delimiter of the rule.
+func CreateRejects(path string, rule models.Rule) (*RejectWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := bufio.NewWriter(file)
+	writer := csv.NewWriter(buf)
+	writer.Comma = delimiterOf(rule)
+	return &RejectWriter{file: file, buf: buf, csv: writer}, nil
+}
+
+// Write adds a rejected row with its errors. headers are the headers of the
+// uploaded file; without them, as for headerless rules, the header line is
+// left out too.
+func (r *RejectWriter) Write(headers []string, line []string, err error) error {
+	if r == nil {
+		return nil
+	}
+
+	if !r.headers {
+		r.stale = make(map[int]bool)
+		for i, header := range headers {
+			if header == RejectsColumn {
+				r.stale[i] = true
+			}
+		}
+		if headers != nil {
+			if err := r.csv.Write(append(r.withoutStale(headers), RejectsColumn)); err != nil {
+				return err
+			}
+		}
+		r.headers = true
+	}
+
+	r.Rows++
+	return r.csv.Write(append(r.withoutStale(line), err.Error()))
+}
+
+// withoutStale copies fields leaving out the errors of an earlier upload.
+func (r *RejectWriter) withoutStale(fields []string) []string {
+	kept := make([]string, 0, len(fields)+1)
+	for i, field := range fields {
+		if !r.stale[i] {
+			kept = append(kept, field)
+		}
+	}
+	return kept
+}
+
+// setDelimiter changes the delimiter before the first row is written.
+func (r *RejectWriter) setDelimiter(comma rune) {
+	if r != nil && !r.headers {
+		r.csv.Comma = comma
+	}
+}
+
+// Close flushes the file and removes it again if no row was rejected.
+func (r *RejectWriter) Close() error {
+	if r == nil {
+		return nil
+	}
+
+	r.csv.Flush()
+	err := r.csv.Error()
+	if flushErr := r.buf.Flush(); err == nil {
+		err = flushErr
+	}
+	if closeErr := r.file.Close(); err == nil {
+		err = closeErr
+	}
+	if r.Rows == 0 {
+		os.Remove(r.file.Name())
+	}
+	return err
+}
@@ -0,0 +1,96 @@
+package pipeline
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// payloadSpool keeps mapped payloads in a temporary file, so report mode
+// can map every row once and deliver only after the whole file is checked.
+type payloadSpool struct {
+	file   *os.File
+	writer *bufio.Writer
+}
+
+type spooledPayload struct {
+	Index   int                    `json:"index"`
+	Group   string                 `json:"group,omitempty"`
+	Payload map[string]interface{} `json:"payload"`
+}
+
+func newPayloadSpool() (*payloadSpool, error) {
+	file, err := os.CreateTemp("", "datenkarte-payloads-*.json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spool file: %v", err)
+	}
+	return &payloadSpool{file: file, writer: bufio.NewWriter(file)}, nil
+}
+
+// Write appends the payload of the row with the given index and group key.
+func (s *payloadSpool) Write(index int, group string, payload map[string]interface{}) error {
+	data, err := json.Marshal(spooledPayload{Index: index, Group: group, Payload: payload})
+	if err != nil {
+		return err
+	}
+	if _, err := s.writer.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write spool file: %v", err)
+	}
+	return nil
+}
+
+// Each calls fn for every spooled payload in the order they were written.
+// Whole numbers are read back as int and other numbers as float64.
+func (s *payloadSpool) Each(fn func(index int, group string, payload map[string]interface{}) error) error {
+	if err := s.writer.Flush(); err != nil {
+		return fmt.Errorf("failed to write spool file: %v", err)
+	}
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind spool file: %v", err)
+	}
+
+	decoder := json.NewDecoder(bufio.NewReader(s.file))
+	decoder.UseNumber()
+	for {
+		var spooled spooledPayload
+		if err := decoder.Decode(&spooled); errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to read spool file: %v", err)
+		}
+		if err := fn(spooled.Index, spooled.Group, restoreNumbers(spooled.Payload).(map[string]interface{})); err != nil {
+			return err
+		}
+	}
+}
+
+// Close removes the spool file.
+func (s *payloadSpool) Close() {
+	s.file.Close()
+	os.Remove(s.file.Name())
+}
+
+func restoreNumbers(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return int(n)
+		}
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+		return v.String()
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = restoreNumbers(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = restoreNumbers(item)
+		}
+	}
+	return value
+}
@@ -0,0 +1,77 @@
+package validation
+
+import (
+	"datenkarte/internal/models"
+)
+
+// Row gives access to the values of a line by header
+type Row map[string]string
+
+// NewRow maps the headers to the values of the line. If a header appears
+// more than once the first column wins.
+func NewRow(line []string, headers []string) Row {
+	row := make(Row, len(headers))
+	for i, header := range headers {
+		if _, exists := row[header]; exists || i >= len(line) {
+			continue
+		}
+		row[header] = line[i]
+	}
+	return row
+}
+
+// EachLines returns the each_line blocks of the rule that apply to the row,
+// in the order of the configuration.
+func EachLines(rule models.Rule, row Row) []models.EachLine {
+	var blocks []models.EachLine
+	for _, eachLine := range rule.EachLine {
+		if MatchConditions(eachLine.When, row) {
+			blocks = append(blocks, eachLine)
+		}
+	}
+	return blocks
+}
+
+// MatchConditions reports whether the row matches all conditions. An empty
+// list matches every row.
+func MatchConditions(conditions []models.Condition, row Row) bool {
+	for _, condition := range conditions {
+		if !matchCondition(condition, row) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchCondition(condition models.Condition, row Row) bool {
+	value := row[condition.Field]
+
+	if condition.Equals != nil && value != *condition.Equals {
+		return false
+	}
+	if condition.NotEquals != nil && value == *condition.NotEquals {
+		return false
+	}
+	if len(condition.In) > 0 && !contains(condition.In, value) {
+		return false
+	}
+	if condition.Empty != nil && (value == "") != *condition.Empty {
+		return false
+	}
+	if condition.Matches != "" {
+		pattern, err := compilePattern(condition.Matches)
+		if err != nil || !pattern.MatchString(value) {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
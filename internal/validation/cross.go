@@ -0,0 +1,105 @@
+package validation
+
+import (
+	"datenkarte/internal/models"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// crossTypes are the validations comparing a field with other fields of the
+// row instead of checking it in isolation.
+var crossTypes = map[string]bool{
+	"required":           true,
+	"required_if":        true,
+	"equals":             true,
+	"before":             true,
+	"after":              true,
+	"mutually_exclusive": true,
+}
+
+// validateCross returns the message describing why the row fails the cross
+// field validation, or an empty string if it passes.
+func validateCross(validation models.Validation, row Row) string {
+	value := row[validation.Field]
+
+	switch validation.Type {
+	case "required":
+		if value == "" {
+			return fmt.Sprintf("field %s is required", validation.Field)
+		}
+	case "required_if":
+		other := row[validation.Other]
+		triggered := other != ""
+		if validation.Value != "" {
+			triggered = other == validation.Value
+		}
+		if triggered && value == "" {
+			if validation.Value != "" {
+				return fmt.Sprintf("field %s is required when %s is %s", validation.Field, validation.Other, validation.Value)
+			}
+			return fmt.Sprintf("field %s is required when %s is set", validation.Field, validation.Other)
+		}
+	case "equals":
+		expected, name := compareTarget(validation, row)
+		if value != expected {
+			return fmt.Sprintf("field %s must equal %s, got: %s", validation.Field, name, value)
+		}
+	case "before", "after":
+		return validateOrder(validation, row)
+	case "mutually_exclusive":
+		var set []string
+		for _, field := range validation.Fields {
+			if row[field] != "" {
+				set = append(set, field)
+			}
+		}
+		if len(set) > 1 {
+			return fmt.Sprintf("fields %s are mutually exclusive, got values for %s", strings.Join(validation.Fields, ", "), strings.Join(set, ", "))
+		}
+	}
+	return ""
+}
+
+// validateOrder compares the dates of the field and the other field or the
+// fixed value. Empty values are left to required validations.
+func validateOrder(validation models.Validation, row Row) string {
+	value := row[validation.Field]
+	target, name := compareTarget(validation, row)
+	if value == "" || target == "" {
+		return ""
+	}
+
+	layout := validation.Layout
+	if layout == "" {
+		layout = DefaultDateLayout
+	}
+	date, err := time.Parse(layout, value)
+	if err != nil {
+		return fmt.Sprintf("field %s must be a date in layout %s, got: %s", validation.Field, layout, value)
+	}
+	targetDate, err := time.Parse(layout, target)
+	if err != nil {
+		return fmt.Sprintf("field %s must be a date in layout %s, got: %s", name, layout, target)
+	}
+
+	if validation.Other != "" {
+		name = fmt.Sprintf("%s (%s)", name, target)
+	}
+	if validation.Type == "before" && !date.Before(targetDate) {
+		return fmt.Sprintf("field %s must be before %s, got: %s", validation.Field, name, value)
+	}
+	if validation.Type == "after" && !date.After(targetDate) {
+		return fmt.Sprintf("field %s must be after %s, got: %s", validation.Field, name, value)
+	}
+	return ""
+}
+
+// compareTarget returns the value a field is compared with and how to name
+// it in messages: the other field if set, else the fixed value.
+func compareTarget(validation models.Validation, row Row) (string, string) {
+	if validation.Other != "" {
+		return row[validation.Other], validation.Other
+	}
+	return validation.Value, validation.Value
+}
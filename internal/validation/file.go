@@ -0,0 +1,154 @@
+package validation
+
+import (
+	"datenkarte/internal/models"
+	"fmt"
+)
+
+// ignoredHeaders are accepted in every file, e.g. the errors column of a
+// re-uploaded rejected rows file.
+var ignoredHeaders = map[string]bool{
+	"_errors": true,
+}
+
+// ValidateHeaders checks the header line against the rule's file
+// validation and returns all violations as Errors.
+func ValidateHeaders(headers []string, rule models.Rule) error {
+	present := make(map[string]bool, len(headers))
+	for _, header := range headers {
+		present[header] = true
+	}
+
+	var errs Errors
+	for _, required := range rule.File.RequiredHeaders {
+		if !present[required] {
+			errs = append(errs, FieldError{
+				Column:  required,
+				Rule:    "required_headers",
+				Message: fmt.Sprintf("required header %s is missing", required),
+			})
+		}
+	}
+
+	if rule.File.NoExtraColumns {
+		known := KnownHeaders(rule)
+		for _, header := range headers {
+			if !known[header] && !ignoredHeaders[header] {
+				errs = append(errs, FieldError{
+					Column:  header,
+					Rule:    "no_extra_columns",
+					Message: fmt.Sprintf("unexpected column %s", header),
+				})
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// KnownHeaders returns every header the rule refers to. Names of mappings
+// computed by expr or collected from other columns are not headers.
+func KnownHeaders(rule models.Rule) map[string]bool {
+	known := make(map[string]bool)
+	for _, header := range rule.File.RequiredHeaders {
+		known[header] = true
+	}
+	for _, header := range rule.File.Unique {
+		known[header] = true
+	}
+	for _, header := range rule.GroupBy {
+		known[header] = true
+	}
+	for _, eachLine := range rule.EachLine {
+		for _, condition := range eachLine.When {
+			known[condition.Field] = true
+		}
+		for _, mapping := range eachLine.Map {
+			if mapping.Expr == "" && len(mapping.From) == 0 {
+				known[mapping.Name] = true
+			}
+			for _, name := range mapping.From {
+				known[name] = true
+			}
+		}
+		for _, validation := range eachLine.Validation {
+			known[validation.Field] = true
+			known[validation.Other] = true
+			for _, field := range validation.Fields {
+				known[field] = true
+			}
+			for _, condition := range validation.When {
+				known[condition.Field] = true
+			}
+		}
+	}
+	delete(known, "")
+	return known
+}
+
+// Uniqueness tracks the values of the rule's unique columns across the rows
+// of a file. A nil Uniqueness checks nothing.
+type Uniqueness struct {
+	columns []string
+	first   map[string]map[string]int
+}
+
+func NewUniqueness(rule models.Rule) *Uniqueness {
+	if len(rule.File.Unique) == 0 {
+		return nil
+	}
+	u := &Uniqueness{
+		columns: rule.File.Unique,
+		first:   make(map[string]map[string]int, len(rule.File.Unique)),
+	}
+	for _, column := range u.columns {
+		u.first[column] = make(map[string]int)
+	}
+	return u
+}
+
+// Add records the values of the row at index unless an earlier row already
+// had them. Empty values are ignored.
+func (u *Uniqueness) Add(index int, row Row) {
+	if u == nil {
+		return
+	}
+	for _, column := range u.columns {
+		value := row[column]
+		if value == "" {
+			continue
+		}
+		if _, exists := u.first[column][value]; !exists {
+			u.first[column][value] = index
+		}
+	}
+}
+
+// Check returns Errors for every value of the row at index that an earlier
+// added row already had.
+func (u *Uniqueness) Check(index int, row Row) error {
+	if u == nil {
+		return nil
+	}
+	var errs Errors
+	for _, column := range u.columns {
+		value := row[column]
+		first, exists := u.first[column][value]
+		if value == "" || !exists || first == index {
+			continue
+		}
+		errs = append(errs, FieldError{
+			Column:  column,
+			Value:   value,
+			Rule:    "unique",
+			Message: fmt.Sprintf("field %s must be unique, %s already appears in row %d", column, value, first+1),
+		})
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package validation
+
+import (
+	"datenkarte/internal/models"
+	"fmt"
+	"strings"
+)
+
+// CanonicalHeaders renames the headers of a file to the names used in the
+// rule, so mappings and validations see the same columns. A mapping claims
+// the header at its column position, then the first header matching its
+// name, aliases or header_pattern. With ignore_case_headers every name of
+// the rule matches regardless of case.
+func CanonicalHeaders(headers []string, rule models.Rule) ([]string, error) {
+	canonical := make([]string, len(headers))
+	for i, header := range headers {
+		canonical[i] = strings.TrimSpace(header)
+	}
+	claimed := make([]bool, len(headers))
+
+	var mappings []models.Mapping
+	for _, eachLine := range rule.EachLine {
+		for _, mapping := range eachLine.Map {
+			if mapping.Expr == "" && len(mapping.From) == 0 {
+				mappings = append(mappings, mapping)
+			}
+		}
+	}
+
+	for _, mapping := range mappings {
+		if mapping.Column < 0 {
+			return nil, fmt.Errorf("column of %s must be positive, got %d", mapping.Name, mapping.Column)
+		}
+		if mapping.Column > 0 && mapping.Column <= len(headers) {
+			canonical[mapping.Column-1] = mapping.Name
+			claimed[mapping.Column-1] = true
+		}
+	}
+
+	// Exact names win over aliases and patterns of other mappings
+	for i, header := range canonical {
+		for _, mapping := range mappings {
+			if !claimed[i] && mapping.Column == 0 && header == mapping.Name && !isClaimed(mapping.Name, canonical, claimed) {
+				claimed[i] = true
+			}
+		}
+	}
+
+	for _, mapping := range mappings {
+		if mapping.Column > 0 || isClaimed(mapping.Name, canonical, claimed) {
+			continue
+		}
+		for i, header := range canonical {
+			if claimed[i] {
+				continue
+			}
+			matched, err := matchHeader(mapping, header, rule.IgnoreCaseHeaders)
+			if err != nil {
+				return nil, err
+			}
+			if matched {
+				canonical[i] = mapping.Name
+				claimed[i] = true
+				break
+			}
+		}
+	}
+
+	if rule.IgnoreCaseHeaders {
+		for name := range KnownHeaders(rule) {
+			if isClaimed(name, canonical, claimed) {
+				continue
+			}
+			for i, header := range canonical {
+				if !claimed[i] && strings.EqualFold(header, name) {
+					canonical[i] = name
+					claimed[i] = true
+					break
+				}
+			}
+		}
+	}
+	return canonical, nil
+}
+
+// isClaimed reports whether a header was already renamed to name.
+func isClaimed(name string, canonical []string, claimed []bool) bool {
+	for i, header := range canonical {
+		if claimed[i] && header == name {
+			return true
+		}
+	}
+	return false
+}
+
+func matchHeader(mapping models.Mapping, header string, ignoreCase bool) (bool, error) {
+	for _, name := range append([]string{mapping.Name}, mapping.Aliases...) {
+		if header == name || (ignoreCase && strings.EqualFold(header, name)) {
+			return true, nil
+		}
+	}
+	if mapping.HeaderPattern == "" {
+		return false, nil
+	}
+	pattern, err := compilePattern(mapping.HeaderPattern)
+	if err != nil {
+		return false, fmt.Errorf("invalid header_pattern of %s: %v", mapping.Name, err)
+	}
+	return pattern.MatchString(header), nil
+}
@@ -0,0 +1,131 @@
+package validation
+
+import (
+	"datenkarte/internal/models"
+	"strings"
+	"testing"
+)
+
+func TestCanonicalHeaders(t *testing.T) {
+	tests := []struct {
+		name       string
+		headers    []string
+		mappings   []models.Mapping
+		validation []models.Validation
+		ignoreCase bool
+		want       []string
+	}{
+		{
+			name:     "exact names are trimmed",
+			headers:  []string{" Last name ", "Email"},
+			mappings: []models.Mapping{{Name: "Last name"}, {Name: "Email"}},
+			want:     []string{"Last name", "Email"},
+		},
+		{
+			name:     "alias",
+			headers:  []string{"Nachname", "Email"},
+			mappings: []models.Mapping{{Name: "Last name", Aliases: []string{"Surname", "Nachname"}}},
+			want:     []string{"Last name", "Email"},
+		},
+		{
+			name:     "header pattern",
+			headers:  []string{"E-Mail Address"},
+			mappings: []models.Mapping{{Name: "Email", HeaderPattern: "(?i)^e-?mail"}},
+			want:     []string{"Email"},
+		},
+		{
+			name:     "only the first matching header is claimed",
+			headers:  []string{"Surname", "Nachname"},
+			mappings: []models.Mapping{{Name: "Last name", Aliases: []string{"Surname", "Nachname"}}},
+			want:     []string{"Last name", "Nachname"},
+		},
+		{
+			name:     "exact name wins over an earlier header matching an alias",
+			headers:  []string{"Surname", "Last name"},
+			mappings: []models.Mapping{{Name: "Last name", Aliases: []string{"Surname"}}},
+			want:     []string{"Surname", "Last name"},
+		},
+		{
+			name:    "exact name wins over the alias of another mapping",
+			headers: []string{"Name", "Other"},
+			mappings: []models.Mapping{
+				{Name: "Full name", Aliases: []string{"Name"}},
+				{Name: "Name"},
+			},
+			want: []string{"Name", "Other"},
+		},
+		{
+			name:    "column position wins over names",
+			headers: []string{"Last name", "Something"},
+			mappings: []models.Mapping{
+				{Name: "Last name", Column: 2},
+				{Name: "First name", Aliases: []string{"Last name"}},
+			},
+			want: []string{"First name", "Last name"},
+		},
+		{
+			name:     "column beyond the headers is ignored",
+			headers:  []string{"A"},
+			mappings: []models.Mapping{{Name: "B", Column: 3}},
+			want:     []string{"A"},
+		},
+		{
+			name:     "case matters by default",
+			headers:  []string{"last name", "NACHNAME"},
+			mappings: []models.Mapping{{Name: "Last name", Aliases: []string{"Nachname"}}},
+			want:     []string{"last name", "NACHNAME"},
+		},
+		{
+			name:       "ignore case for names and aliases",
+			headers:    []string{"NACHNAME", "email"},
+			mappings:   []models.Mapping{{Name: "Last name", Aliases: []string{"Nachname"}}, {Name: "Email"}},
+			ignoreCase: true,
+			want:       []string{"Last name", "Email"},
+		},
+		{
+			name:       "ignore case for validated columns",
+			headers:    []string{"AGE"},
+			validation: []models.Validation{{Field: "Age", Type: "integer"}},
+			ignoreCase: true,
+			want:       []string{"Age"},
+		},
+		{
+			name:       "mappings computed by expr or from claim no header",
+			headers:    []string{"FULL", "TAGS"},
+			mappings:   []models.Mapping{{Name: "full", Expr: "1"}, {Name: "tags", From: []string{"A"}}},
+			ignoreCase: true,
+			want:       []string{"FULL", "TAGS"},
+		},
+	}
+	for _, test := range tests {
+		rule := models.Rule{
+			IgnoreCaseHeaders: test.ignoreCase,
+			EachLine:          []models.EachLine{{Map: test.mappings, Validation: test.validation}},
+		}
+		got, err := CanonicalHeaders(test.headers, rule)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if strings.Join(got, "|") != strings.Join(test.want, "|") {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCanonicalHeadersErrors(t *testing.T) {
+	tests := []struct {
+		mapping models.Mapping
+		want    string
+	}{
+		{models.Mapping{Name: "A", Column: -1}, "column of A must be positive, got -1"},
+		{models.Mapping{Name: "A", HeaderPattern: "("}, "invalid header_pattern of A"},
+	}
+	for _, test := range tests {
+		rule := models.Rule{EachLine: []models.EachLine{{Map: []models.Mapping{test.mapping}}}}
+		_, err := CanonicalHeaders([]string{"X"}, rule)
+		if err == nil || !strings.HasPrefix(err.Error(), test.want) {
+			t.Errorf("%+v: error = %v, want %q", test.mapping, err, test.want)
+		}
+	}
+}
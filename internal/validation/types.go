@@ -0,0 +1,161 @@
+package validation
+
+import (
+	"datenkarte/internal/models"
+	"fmt"
+	"math"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// DefaultDateLayout is the layout of dates without a configured layout
+const DefaultDateLayout = "2006-01-02"
+
+var (
+	// DecimalPattern matches decimal numbers like 12.50
+	DecimalPattern = regexp.MustCompile(`^[+-]?[0-9]+(\.[0-9]+)?$`)
+
+	uuidPattern  = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	phonePattern = regexp.MustCompile(`^\+[1-9][0-9]{1,14}$`)
+
+	booleanValues = []string{"true", "false", "1", "0", "yes", "no", "y", "n"}
+
+	patterns   = make(map[string]*regexp.Regexp)
+	patternsMu sync.Mutex
+)
+
+// validate returns the message describing why value fails the validation,
+// or an empty string if it passes.
+func validate(validation models.Validation, header string, value string) string {
+	if value == "" && validation.Optional {
+		return ""
+	}
+
+	if message := validateType(validation, header, value); message != "" {
+		return message
+	}
+	return validateLength(validation, header, value)
+}
+
+func validateType(validation models.Validation, header string, value string) string {
+	switch validation.Type {
+	case "number":
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+			return fmt.Sprintf("field %s must be a number, got: %s", header, value)
+		}
+		return validateRange(validation, header, value, n)
+	case "decimal":
+		if !DecimalPattern.MatchString(value) {
+			return fmt.Sprintf("field %s must be a decimal number like 12.50, got: %s", header, value)
+		}
+		n, _ := strconv.ParseFloat(value, 64)
+		return validateRange(validation, header, value, n)
+	case "integer":
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Sprintf("field %s must be an integer, got: %s", header, value)
+		}
+		return validateRange(validation, header, value, float64(n))
+	case "string":
+		if len(value) == 0 {
+			return fmt.Sprintf("field %s must be a non-empty string", header)
+		}
+	case "email":
+		address, err := mail.ParseAddress(value)
+		if err != nil || address.Address != value {
+			return fmt.Sprintf("field %s must be a valid email, got: %s", header, value)
+		}
+	case "regex":
+		pattern, err := compilePattern(validation.Pattern)
+		if err != nil || !pattern.MatchString(value) {
+			return fmt.Sprintf("field %s does not match pattern %s, got: %s", header, validation.Pattern, value)
+		}
+	case "date":
+		layout := validation.Layout
+		if layout == "" {
+			layout = DefaultDateLayout
+		}
+		if _, err := time.Parse(layout, value); err != nil {
+			return fmt.Sprintf("field %s must be a date in layout %s, got: %s", header, layout, value)
+		}
+	case "enum":
+		for _, allowed := range validation.Values {
+			if value == allowed || (validation.IgnoreCase && strings.EqualFold(value, allowed)) {
+				return ""
+			}
+		}
+		return fmt.Sprintf("field %s must be one of %s, got: %s", header, strings.Join(validation.Values, ", "), value)
+	case "boolean":
+		for _, allowed := range booleanValues {
+			if strings.EqualFold(value, allowed) {
+				return ""
+			}
+		}
+		return fmt.Sprintf("field %s must be a boolean, got: %s", header, value)
+	case "url":
+		u, err := url.ParseRequestURI(value)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Sprintf("field %s must be a valid http or https URL, got: %s", header, value)
+		}
+	case "uuid":
+		if !uuidPattern.MatchString(value) {
+			return fmt.Sprintf("field %s must be a UUID, got: %s", header, value)
+		}
+	case "phone":
+		if !phonePattern.MatchString(value) {
+			return fmt.Sprintf("field %s must be a phone number in E.164 format like +4930123456, got: %s", header, value)
+		}
+	case "length":
+	default:
+		return fmt.Sprintf("unknown validation type: %s for field %s", validation.Type, header)
+	}
+	return ""
+}
+
+func validateRange(validation models.Validation, header string, value string, n float64) string {
+	if validation.Min != nil && n < *validation.Min {
+		return fmt.Sprintf("field %s must be at least %s, got: %s", header, formatNumber(*validation.Min), value)
+	}
+	if validation.Max != nil && n > *validation.Max {
+		return fmt.Sprintf("field %s must be at most %s, got: %s", header, formatNumber(*validation.Max), value)
+	}
+	return ""
+}
+
+func validateLength(validation models.Validation, header string, value string) string {
+	length := utf8.RuneCountInString(value)
+	if validation.MinLength != nil && length < *validation.MinLength {
+		return fmt.Sprintf("field %s must be at least %d characters long, got %d", header, *validation.MinLength, length)
+	}
+	if validation.MaxLength != nil && length > *validation.MaxLength {
+		return fmt.Sprintf("field %s must be at most %d characters long, got %d", header, *validation.MaxLength, length)
+	}
+	return ""
+}
+
+func formatNumber(n float64) string {
+	return strconv.FormatFloat(n, 'f', -1, 64)
+}
+
+// compilePattern compiles a regex once and caches it for the following rows.
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	patternsMu.Lock()
+	defer patternsMu.Unlock()
+
+	if compiled, exists := patterns[pattern]; exists {
+		return compiled, nil
+	}
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	patterns[pattern] = compiled
+	return compiled, nil
+}
@@ -2,42 +2,74 @@ package validation
 
 import (
 	"datenkarte/internal/models"
-	"fmt"
-	"regexp"
-	"strconv"
 	"strings"
-
-	"github.com/gin-gonic/gin"
 )
 
-func ValidateLine(c *gin.Context, line []string, headers []string, rule models.Rule) error {
-	for _, validation := range rule.EachLine[0].Validation {
-		for i, header := range headers {
-			if validation.Field == header {
-				value := line[i]
-				switch validation.Type {
-				case "number":
-					if _, err := strconv.Atoi(value); err != nil {
-						return fmt.Errorf("field %s must be a number, got: %s", header, value)
-					}
-				case "string":
-					if len(value) == 0 {
-						return fmt.Errorf("field %s must be a non-empty string", header)
-					}
-				case "email":
-					if !strings.Contains(value, "@") {
-						return fmt.Errorf("field %s must be a valid email, got: %s", header, value)
-					}
-				case "regex":
-					matched, err := regexp.MatchString(validation.Pattern, value)
-					if err != nil || !matched {
-						return fmt.Errorf("field %s does not match pattern %s, got: %s", header, validation.Pattern, value)
-					}
-				default:
-					return fmt.Errorf("unknown validation type: %s for field %s", validation.Type, header)
-				}
-			}
+// FieldError describes a single failed validation of a row
+type FieldError struct {
+	Row     int    `json:"row"`
+	Column  string `json:"column,omitempty"`
+	Value   string `json:"value,omitempty"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+func (e FieldError) Error() string {
+	return e.Message
+}
+
+// Errors collects every failed validation of a row
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// ValidateLine checks every validation of the each_line blocks applying to
+// the line whose when conditions match and returns all violations as Errors,
+// or nil if the line is valid.
+func ValidateLine(line []string, headers []string, rule models.Rule) error {
+	row := NewRow(line, headers)
+
+	var validations []models.Validation
+	for _, eachLine := range EachLines(rule, row) {
+		validations = append(validations, eachLine.Validation...)
+	}
+
+	var errs Errors
+	for _, validation := range validations {
+		if !MatchConditions(validation.When, row) {
+			continue
 		}
+
+		column := validation.Field
+		if validation.Type == "mutually_exclusive" {
+			column = strings.Join(validation.Fields, ", ")
+		}
+		value, exists := row[validation.Field]
+
+		var message string
+		if crossTypes[validation.Type] {
+			message = validateCross(validation, row)
+		} else if exists {
+			message = validate(validation, validation.Field, value)
+		}
+
+		if message != "" {
+			errs = append(errs, FieldError{
+				Column:  column,
+				Value:   value,
+				Rule:    validation.Type,
+				Message: message,
+			})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
 	}
 	return nil
 }